@@ -0,0 +1,279 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/fileutils"
+)
+
+// joins name onto root, refusing anything that would land outside of it
+// either through .. or through a symlink extracted earlier
+func safeJoin(root string, name string) (string, error) {
+	target := filepath.Join(root, filepath.FromSlash(name))
+	if target != root && !strings.HasPrefix(target, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %q escapes %s", name, root)
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if err == nil && parent != root && !strings.HasPrefix(parent, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %q escapes %s through a symlink", name, root)
+	}
+	return target, nil
+}
+
+// extracts a tar stream into dest, dropping the leading strip directory every
+// entry is nested under. The contents come from an untrusted repository, so
+// nothing is allowed to be written outside dest.
+func extractTar(r io.Reader, dest string, strip string) error {
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(hdr.Name, strip), "/")
+		if name == "" {
+			continue
+		}
+		target, err := safeJoin(root, name)
+		if err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode().Perm()
+		// a file or symlink left by an earlier dump into the same directory is
+		// replaced, never written through
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeSymlink {
+			if err := removeNonDir(target); err != nil {
+				return err
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY|oNoFollow, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(file, tr); err != nil {
+				file.Close()
+				return err
+			}
+			if err := file.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+		// anything else (devices, fifos, hard links) has no place in a git checkout
+	}
+}
+
+// removes whatever isn't a directory at target, so extracting a file over it
+// can't follow a symlink out of the destination
+func removeNonDir(target string) error {
+	info, err := os.Lstat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory, not replacing it with a file", target)
+	}
+	return os.Remove(target)
+}
+
+// checks the build context is a readable, non-empty tar (optionally gzipped)
+// with a Dockerfile at its root, so a broken embed fails with a clear message
+// instead of an opaque error from the daemon
+func validateBuildContext(fsys fs.FS, name string) error {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return fmt.Errorf("reading build context %s: %w", name, err)
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("build context %s is empty", name)
+	}
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("build context %s is not valid gzip: %w", name, err)
+		}
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("build context %s has no Dockerfile", name)
+		}
+		if err != nil {
+			return fmt.Errorf("build context %s is not a valid tar: %w", name, err)
+		}
+		if path.Clean(hdr.Name) == "Dockerfile" {
+			return nil
+		}
+	}
+}
+
+// reads the patterns of a .dockerignore at the root of dir the way docker's
+// dockerignore.ReadAll does, a missing file ignores nothing
+func readDockerignore(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ".dockerignore"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		negate := strings.HasPrefix(line, "!")
+		line = strings.TrimSpace(strings.TrimPrefix(line, "!"))
+		if line == "" {
+			continue
+		}
+		line = filepath.ToSlash(filepath.Clean(line))
+		if len(line) > 1 && line[0] == '/' {
+			line = line[1:]
+		}
+		if negate {
+			line = "!" + line
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
+// tars dir as a build context, leaving out what its .dockerignore excludes
+// with the same matcher docker build uses. Like the docker CLI, the
+// Dockerfile and .dockerignore are always sent, the daemon needs them.
+func tarBuildContext(dir string, dockerfile string) (io.ReadCloser, error) {
+	patterns, err := readDockerignore(dir)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := fileutils.NewPatternMatcher(append(patterns, "!.dockerignore", "!"+dockerfile))
+	if err != nil {
+		return nil, fmt.Errorf(".dockerignore: %w", err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, dir, func(name string, d fs.DirEntry) error {
+			skip, err := pm.Matches(name)
+			if err != nil || !skip {
+				return err
+			}
+			if !d.IsDir() {
+				return errSkipEntry
+			}
+			// an ignored directory is still walked when a !pattern may
+			// bring back something below it
+			for _, p := range pm.Patterns() {
+				if p.Exclusion() && strings.HasPrefix(filepath.ToSlash(p.String())+"/", name+"/") {
+					return errSkipEntry
+				}
+			}
+			return filepath.SkipDir
+		}))
+	}()
+	return pr, nil
+}
+
+// returned by a writeTar filter to leave out a single entry
+var errSkipEntry = errors.New("skip entry")
+
+// writes dir to w as a tar with slash separated names relative to dir.
+// filter, when not nil, sees every entry first and can leave it out with
+// errSkipEntry, or a whole directory with filepath.SkipDir.
+func writeTar(w io.Writer, dir string, filter func(name string, d fs.DirEntry) error) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if filter != nil {
+			if err := filter(name, d); err == errSkipEntry {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// one entry of a test tar: a file with body, a directory when name ends in /,
+// or a symlink when link is set
+type tarEntry struct {
+	name string
+	body string
+	link string
+}
+
+func testTar(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		switch {
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		case e.name[len(e.name)-1] == '/':
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &b
+}
+
+func TestExtractTar(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		// files expected inside dest afterwards, by contents
+		want  map[string]string
+		fails bool
+	}{
+		{
+			name:    "files and directories",
+			entries: []tarEntry{{name: "git/"}, {name: "git/.git/"}, {name: "git/.git/HEAD", body: "ref: refs/heads/main\n"}, {name: "git/README", body: "hi"}},
+			want:    map[string]string{".git/HEAD": "ref: refs/heads/main\n", "README": "hi"},
+		},
+		{
+			name:    "dot dot",
+			entries: []tarEntry{{name: "git/../../victim", body: "x"}},
+			fails:   true,
+		},
+		{
+			name:    "through a symlinked directory",
+			entries: []tarEntry{{name: "git/out", link: ".."}, {name: "git/out/victim", body: "x"}},
+			fails:   true,
+		},
+		{
+			name:    "over a symlink in the same archive",
+			entries: []tarEntry{{name: "git/cfg", link: "../victim"}, {name: "git/cfg", body: "x"}},
+			want:    map[string]string{"cfg": "x"},
+		},
+		{
+			name:    "same symlink twice",
+			entries: []tarEntry{{name: "git/l", link: "README"}, {name: "git/l", link: "other"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			dest := filepath.Join(base, "dest")
+			if err := os.Mkdir(dest, 0755); err != nil {
+				t.Fatal(err)
+			}
+			victim := filepath.Join(base, "victim")
+			if err := os.WriteFile(victim, []byte("untouched"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := extractTar(testTar(t, tt.entries...), dest, "git")
+			if tt.fails != (err != nil) {
+				t.Fatalf("extractTar returned %v", err)
+			}
+			if data, _ := os.ReadFile(victim); string(data) != "untouched" {
+				t.Fatalf("a file outside dest was written: %q", data)
+			}
+			for name, body := range tt.want {
+				data, err := os.ReadFile(filepath.Join(dest, name))
+				if err != nil || string(data) != body {
+					t.Errorf("%s is %q (%v), want %q", name, data, err, body)
+				}
+			}
+		})
+	}
+}
+
+// an earlier dump left a symlink where the next copy-out writes a file
+func TestExtractTarOverExistingSymlink(t *testing.T) {
+	base := t.TempDir()
+	dest := filepath.Join(base, "dest")
+	victim := filepath.Join(base, "victim")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(victim, []byte("untouched"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractTar(testTar(t, tarEntry{name: "git/cfg", link: "../victim"}, tarEntry{name: "git/l", link: "cfg"}), dest, "git"); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractTar(testTar(t, tarEntry{name: "git/cfg", body: "new"}, tarEntry{name: "git/l", link: "cfg"}), dest, "git"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(victim); string(data) != "untouched" {
+		t.Fatalf("extracting over a symlink wrote through it: %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "cfg")); string(data) != "new" {
+		t.Fatalf("cfg is %q, want the new contents", data)
+	}
+}
+
+func TestValidateBuildContext(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(testTar(t, tarEntry{name: "Dockerfile", body: "FROM scratch\n"}).Bytes())
+	zw.Close()
+
+	tests := []struct {
+		name string
+		data []byte
+		// substring of the expected error, "" when it is valid
+		want string
+	}{
+		{"missing", nil, "reading build context"},
+		{"empty", []byte{}, "is empty"},
+		{"plain tar", testTar(t, tarEntry{name: "Dockerfile", body: "FROM scratch\n"}).Bytes(), ""},
+		{"gzipped tar", gz.Bytes(), ""},
+		{"bad gzip", []byte{0x1f, 0x8b, 0, 0}, "not valid gzip"},
+		{"not a tar", bytes.Repeat([]byte("x"), 1024), "not a valid tar"},
+		{"no Dockerfile", testTar(t, tarEntry{name: "README", body: "hi"}).Bytes(), "has no Dockerfile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			if tt.data != nil {
+				fsys["ctx.tar"] = &fstest.MapFile{Data: tt.data}
+			}
+			err := validateBuildContext(fsys, "ctx.tar")
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// the build context gget ships with has to pass its own check
+func TestValidateBuildContextEmbedded(t *testing.T) {
+	if err := validateBuildContext(f, "Dockerfile.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTarBuildContext(t *testing.T) {
+	tests := []struct {
+		name      string
+		ignore    string
+		want      []string
+		notWanted []string
+	}{
+		{
+			name: "no .dockerignore",
+			want: []string{"Dockerfile", "app/main.go", "app/vendor/lib.go", "docs/notes.md", "build.log"},
+		},
+		{
+			name:      "directory and glob",
+			ignore:    "# comments are fine\ndocs\n*.log\n",
+			want:      []string{"Dockerfile", ".dockerignore", "app/main.go"},
+			notWanted: []string{"docs/notes.md", "build.log"},
+		},
+		{
+			name:      "double star",
+			ignore:    "**/*.go\n",
+			want:      []string{"Dockerfile", "docs/notes.md"},
+			notWanted: []string{"app/main.go", "app/vendor/lib.go"},
+		},
+		{
+			name:      "exception below an ignored directory",
+			ignore:    "app\n!app/vendor/lib.go\n",
+			want:      []string{"app/vendor/lib.go"},
+			notWanted: []string{"app/main.go"},
+		},
+		{
+			name:   "the Dockerfile and .dockerignore are always sent",
+			ignore: "Dockerfile\n.dockerignore\n",
+			want:   []string{"Dockerfile", ".dockerignore"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{"Dockerfile": "FROM scratch\n", "app/main.go": "", "app/vendor/lib.go": "", "docs/notes.md": "", "build.log": ""}
+			if tt.ignore != "" {
+				files[".dockerignore"] = tt.ignore
+			}
+			for name, body := range files {
+				p := filepath.Join(dir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte(body), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rc, err := tarBuildContext(dir, "Dockerfile")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			sent := map[string]bool{}
+			tr := tar.NewReader(rc)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				sent[hdr.Name] = true
+			}
+			for _, name := range tt.want {
+				if !sent[name] {
+					t.Errorf("%s was left out", name)
+				}
+			}
+			for _, name := range tt.notWanted {
+				if sent[name] {
+					t.Errorf("%s was sent", name)
+				}
+			}
+		})
+	}
+}
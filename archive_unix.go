@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// keeps extractTar from opening a file through a symlink swapped in after
+// removeNonDir
+const oNoFollow = syscall.O_NOFOLLOW
@@ -0,0 +1,5 @@
+package main
+
+// Windows has no O_NOFOLLOW, extractTar relies on removeNonDir and O_EXCL
+// there, which refuses to open a symlink left in the way
+const oNoFollow = 0
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// the parts of the docker cli's config.json gget understands
+type dockerConfigFile struct {
+	Auths       map[string]types.AuthConfig  `json:"auths"`
+	CredsStore  string                       `json:"credsStore"`
+	CredHelpers map[string]string            `json:"credHelpers"`
+	Proxies     map[string]dockerProxyConfig `json:"proxies"`
+}
+
+// proxy settings the docker cli hands to builds and containers, keyed by
+// daemon host or "default"
+type dockerProxyConfig struct {
+	HTTPProxy  string `json:"httpProxy"`
+	HTTPSProxy string `json:"httpsProxy"`
+	NoProxy    string `json:"noProxy"`
+	FTPProxy   string `json:"ftpProxy"`
+}
+
+// where the docker cli keeps its config, $DOCKER_CONFIG wins over ~/.docker
+func defaultDockerConfig() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	return "~/.docker/config.json"
+}
+
+// returns the proxy variables the docker cli would set for host, falling back
+// to its "default" entry, as both upper and lower case names like it does
+func LoadProxyEnv(path string, host string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config dockerConfigFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	proxy, ok := config.Proxies[host]
+	if !ok {
+		proxy = config.Proxies["default"]
+	}
+	var env []string
+	for _, v := range []struct{ name, value string }{
+		{"HTTP_PROXY", proxy.HTTPProxy},
+		{"HTTPS_PROXY", proxy.HTTPSProxy},
+		{"NO_PROXY", proxy.NoProxy},
+		{"FTP_PROXY", proxy.FTPProxy},
+	} {
+		if v.value != "" {
+			env = append(env, v.name+"="+v.value, strings.ToLower(v.name)+"="+v.value)
+		}
+	}
+	return env, nil
+}
+
+// what docker-credential-* helpers print for "get"
+type helperCredentials struct {
+	Username string `json:"Username"`
+	Secret   string `json:"Secret"`
+}
+
+// reads registry credentials from a docker cli config file the same way
+// `docker build` would, including credential helpers. A missing file just
+// means there are no credentials, and like the docker cli a helper that fails
+// only costs its registry's credentials, with a warning, since most builds
+// pull nothing that needs them.
+func LoadRegistryAuth(path string) (map[string]types.AuthConfig, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]types.AuthConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config dockerConfigFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	auths := map[string]types.AuthConfig{}
+	for registry, auth := range config.Auths {
+		// "auth" is base64 of user:password, the split fields are optional
+		if auth.Auth != "" && auth.Username == "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("decoding auth for %s in %s: %w", registry, path, err)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+			auth.Auth = ""
+		}
+		auth.ServerAddress = registry
+		auths[registry] = auth
+	}
+
+	helpers := map[string]string{}
+	if config.CredsStore != "" {
+		for registry := range config.Auths {
+			helpers[registry] = config.CredsStore
+		}
+	}
+	for registry, helper := range config.CredHelpers {
+		helpers[registry] = helper
+	}
+	for registry, helper := range helpers {
+		auth, err := helperAuth(helper, registry)
+		if err != nil {
+			logWarning("", "AUTH", fmt.Sprintf("no credentials for %s: %v", registry, err))
+			continue
+		}
+		auths[registry] = auth
+	}
+	return auths, nil
+}
+
+func helperAuth(helper string, registry string) (types.AuthConfig, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return types.AuthConfig{}, fmt.Errorf("docker-credential-%s get %s: %w: %s", helper, registry, err, strings.TrimSpace(stderr.String()))
+	}
+	var creds helperCredentials
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return types.AuthConfig{}, fmt.Errorf("docker-credential-%s get %s: %w", helper, registry, err)
+	}
+	auth := types.AuthConfig{ServerAddress: registry}
+	// helpers store identity tokens under a placeholder user name
+	if creds.Username == "<token>" {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username = creds.Username
+		auth.Password = creds.Secret
+	}
+	return auth, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestLoadRegistryAuth(t *testing.T) {
+	// a credential helper that knows one registry
+	bin := t.TempDir()
+	helper := "#!/bin/sh\nread registry\n[ \"$registry\" = registry.example.com ] || { echo \"credentials not found\" >&2; exit 1; }\necho '{\"Username\":\"robot\",\"Secret\":\"s3cret\"}'\n"
+	if err := os.WriteFile(filepath.Join(bin, "docker-credential-fake"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		name   string
+		config string
+		want   map[string]types.AuthConfig
+		fails  bool
+	}{
+		{
+			name:   "inline auth",
+			config: `{"auths": {"docker.io": {"auth": "dXNlcjpwYXNz"}}}`,
+			want:   map[string]types.AuthConfig{"docker.io": {Username: "user", Password: "pass", ServerAddress: "docker.io"}},
+		},
+		{
+			name:   "credential helper",
+			config: `{"credHelpers": {"registry.example.com": "fake"}}`,
+			want:   map[string]types.AuthConfig{"registry.example.com": {Username: "robot", Password: "s3cret", ServerAddress: "registry.example.com"}},
+		},
+		{
+			name:   "failing helper is skipped",
+			config: `{"credHelpers": {"other.example.com": "fake", "registry.example.com": "fake"}}`,
+			want:   map[string]types.AuthConfig{"registry.example.com": {Username: "robot", Password: "s3cret", ServerAddress: "registry.example.com"}},
+		},
+		{
+			name:   "missing helper is skipped",
+			config: `{"credsStore": "gget-missing", "auths": {"docker.io": {}}}`,
+			want:   map[string]types.AuthConfig{"docker.io": {ServerAddress: "docker.io"}},
+		},
+		{name: "not json", config: `{`, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadRegistryAuth(path)
+			if tt.fails != (err != nil) {
+				t.Fatalf("LoadRegistryAuth returned %v", err)
+			}
+			if !tt.fails && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRegistryAuthMissingFile(t *testing.T) {
+	got, err := LoadRegistryAuth(filepath.Join(t.TempDir(), "config.json"))
+	if err != nil || len(got) != 0 {
+		t.Fatalf("got %v, %v, want no credentials", got, err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/ttacon/chalk"
+)
+
+// set at build time with -ldflags "-X main.version=v1.2.3", otherwise taken
+// from the module version go install recorded
+var version = ""
+
+func ggetVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// prints what a bug report needs to know about this run: gget's version, the
+// daemon it talks to and the settings that change how the dump behaves
+func PrintBanner(ctx context.Context, w io.Writer, host string, settings []string) {
+	fmt.Fprintf(w, "%s %s (%s %s/%s)\n", chalk.Bold.TextStyle("gget"), ggetVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	cli, err := newClient(host, "", nil)
+	if err == nil {
+		defer cli.Close()
+		if host == "" {
+			host = cli.DaemonHost()
+		}
+		var v types.Version
+		if v, err = cli.ServerVersion(ctx); err == nil {
+			fmt.Fprintf(w, "%s %s (api %s, %s/%s) at %s\n", chalk.Bold.TextStyle("docker"), v.Version, v.APIVersion, v.Os, v.Arch, host)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(w, "%s %s\n", chalk.Bold.TextStyle("docker"), chalk.Red.Color("unreachable: "+err.Error()))
+	}
+	fmt.Fprintf(w, "%s %s\n", chalk.Bold.TextStyle("settings"), strings.Join(settings, " "))
+}
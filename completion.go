@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type boolFlag interface {
+	IsBoolFlag() bool
+}
+
+// flags that take a directory rather than an arbitrary file
+var directoryFlags = map[string]bool{
+	"o":      true,
+	"mirror": true,
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(boolFlag)
+	return ok && b.IsBoolFlag()
+}
+
+// usage strings repeat the flag name and quote their placeholder, neither of
+// which reads well next to a completion
+func flagDescription(f *flag.Flag) string {
+	desc := strings.TrimPrefix(f.Usage, "-"+f.Name)
+	return strings.TrimSpace(strings.ReplaceAll(desc, `"`, ""))
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func sortedSubcommands() []string {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writes a completion script for shell covering every flag registered on fs
+func WriteCompletion(w io.Writer, shell string, fs *flag.FlagSet) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, fs)
+	case "zsh":
+		writeZshCompletion(w, fs)
+	case "fish":
+		writeFishCompletion(w, fs)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, fs *flag.FlagSet) {
+	var flags, valued []string
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, "-"+f.Name)
+		if !isBoolFlag(f) {
+			valued = append(valued, "-"+f.Name)
+		}
+	})
+	var dirs []string
+	for name := range directoryFlags {
+		dirs = append(dirs, "-"+name)
+	}
+	sort.Strings(dirs)
+
+	fmt.Fprintf(w, `_gget() {
+	local cur prev
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]}"
+	if [ "$COMP_CWORD" -eq 2 ] && [ "${COMP_WORDS[1]}" = "completion" ]; then
+		COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+		return
+	fi
+	case "$prev" in
+	%s)
+		COMPREPLY=($(compgen -d -- "$cur"))
+		return
+		;;
+	%s)
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	esac
+	if [ "$COMP_CWORD" -eq 1 ] && [[ "$cur" != -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -W "%s" -- "$cur"))
+}
+complete -F _gget gget
+`, strings.Join(dirs, "|"), strings.Join(valued, "|"), strings.Join(sortedSubcommands(), " "), strings.Join(flags, " "))
+}
+
+func writeZshCompletion(w io.Writer, fs *flag.FlagSet) {
+	replacer := strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`)
+	fmt.Fprintln(w, "#compdef gget")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_gget() {")
+	fmt.Fprintln(w, "\tif (( CURRENT == 3 )) && [[ ${words[2]} == completion ]]; then")
+	fmt.Fprintln(w, "\t\t_values shell bash zsh fish")
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tlocal -a subcommands")
+	fmt.Fprintln(w, "\tsubcommands=(")
+	for _, name := range sortedSubcommands() {
+		fmt.Fprintf(w, "\t\t%s\n", shellQuote(name+":"+replacer.Replace(subcommands[name])))
+	}
+	fmt.Fprintln(w, "\t)")
+	fmt.Fprintln(w, "\t_arguments \\")
+	fs.VisitAll(func(f *flag.Flag) {
+		spec := "-" + f.Name + "[" + replacer.Replace(flagDescription(f)) + "]"
+		switch {
+		case isBoolFlag(f):
+		case directoryFlags[f.Name]:
+			spec += ":" + f.Name + ":_files -/"
+		default:
+			spec += ":" + f.Name + ":_files"
+		}
+		fmt.Fprintf(w, "\t\t%s \\\n", shellQuote(spec))
+	})
+	fmt.Fprintln(w, "\t\t'1: :_describe command subcommands'")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `if [ "$funcstack[1]" = "_gget" ]; then`)
+	fmt.Fprintln(w, `	_gget "$@"`)
+	fmt.Fprintln(w, "else")
+	fmt.Fprintln(w, "	compdef _gget gget")
+	fmt.Fprintln(w, "fi")
+}
+
+func writeFishCompletion(w io.Writer, fs *flag.FlagSet) {
+	for _, name := range sortedSubcommands() {
+		fmt.Fprintf(w, "complete -c gget -f -n __fish_use_subcommand -a %s -d %s\n", name, shellQuote(subcommands[name]))
+	}
+	fmt.Fprintln(w, "complete -c gget -f -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'")
+	fs.VisitAll(func(f *flag.Flag) {
+		line := "complete -c gget -o " + f.Name + " -d " + shellQuote(flagDescription(f))
+		switch {
+		case isBoolFlag(f):
+		case directoryFlags[f.Name]:
+			line += " -r -a '(__fish_complete_directories)'"
+		default:
+			line += " -r -F"
+		}
+		fmt.Fprintln(w, line)
+	})
+}
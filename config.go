@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// files fetched by -dump-config-only, only config is required
+var configFiles = []string{"config", "HEAD", "packed-refs"}
+
+// what -dump-config-only found out about the repository
+type configInfo struct {
+	// "<name> <url>" per remote, with any credentials redacted
+	Remotes []string
+	// "<branch> -> <remote>/<merge>" per tracked branch
+	Branches []string
+	// the ref HEAD points at, or the sha of a detached HEAD
+	Head string
+	// "<sha> <refname>" from packed-refs
+	Refs []string
+	// one line per credential found in the config, without the secret itself
+	Credentials []string
+}
+
+// downloads config, HEAD and packed-refs into output/.git and reads what they
+// say about where the repository came from
+func FetchConfig(ctx context.Context, hc *http.Client, url string, output string, mode os.FileMode) (configInfo, error) {
+	base := gitBaseURL(url)
+	gitDir := filepath.Join(output, ".git")
+	if err := os.MkdirAll(gitDir, mode); err != nil {
+		return configInfo{}, err
+	}
+	var info configInfo
+	for _, name := range configFiles {
+		data, err := fetch(ctx, hc, base+name)
+		if err != nil {
+			if name == "config" {
+				return configInfo{}, err
+			}
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(gitDir, name), data, 0644); err != nil {
+			return configInfo{}, err
+		}
+		switch name {
+		case "config":
+			parseGitConfig(data, &info)
+		case "HEAD":
+			info.Head = strings.TrimPrefix(strings.TrimSpace(string(data)), "ref: ")
+		case "packed-refs":
+			s := bufio.NewScanner(bytes.NewReader(data))
+			for s.Scan() {
+				line := s.Text()
+				if line == "" || line[0] == '#' || line[0] == '^' {
+					continue
+				}
+				if fields := strings.Fields(line); len(fields) == 2 {
+					info.Refs = append(info.Refs, fields[0]+" "+fields[1])
+				}
+			}
+		}
+	}
+	return info, nil
+}
+
+// reads remotes, tracked branches and credentials out of a git config. Only
+// as much of the format as these need is understood: sections, subsections
+// and key = value lines.
+func parseGitConfig(data []byte, info *configInfo) {
+	var section, subsection string
+	branches := map[string][2]string{}
+	var order []string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			header := strings.Trim(line, "[]")
+			section, subsection, _ = strings.Cut(header, " ")
+			section = strings.ToLower(section)
+			subsection = strings.Trim(subsection, `"`)
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		where := section
+		if subsection != "" {
+			where += "." + subsection
+		}
+		switch {
+		case section == "remote" && (key == "url" || key == "pushurl"):
+			u, err := neturl.Parse(value)
+			if err == nil && u.User != nil {
+				if _, ok := u.User.Password(); ok {
+					info.Credentials = append(info.Credentials, fmt.Sprintf("%s.%s embeds a password for %s", where, key, u.User.Username()))
+					value = u.Redacted()
+				} else if u.Scheme == "http" || u.Scheme == "https" {
+					// a token alone goes where the user name would, ssh's git@ doesn't count
+					info.Credentials = append(info.Credentials, fmt.Sprintf("%s.%s embeds the user %s, possibly a token", where, key, redactSecret(u.User.Username())))
+					u.User = neturl.User(redactSecret(u.User.Username()))
+					value = u.String()
+				}
+			}
+			if key == "url" {
+				info.Remotes = append(info.Remotes, subsection+" "+value)
+			}
+		case section == "branch" && (key == "remote" || key == "merge"):
+			if _, ok := branches[subsection]; !ok {
+				order = append(order, subsection)
+			}
+			b := branches[subsection]
+			if key == "remote" {
+				b[0] = value
+			} else {
+				b[1] = strings.TrimPrefix(value, "refs/heads/")
+			}
+			branches[subsection] = b
+		case section == "http" && key == "extraheader" && strings.HasPrefix(strings.ToLower(value), "authorization:"):
+			info.Credentials = append(info.Credentials, fmt.Sprintf("%s.%s sends an Authorization header", where, key))
+		case key == "password" || key == "token":
+			info.Credentials = append(info.Credentials, fmt.Sprintf("%s.%s is set", where, key))
+		}
+	}
+	for _, name := range order {
+		b := branches[name]
+		info.Branches = append(info.Branches, fmt.Sprintf("%s -> %s/%s", name, b[0], b[1]))
+	}
+}
+
+// keeps enough of a secret to recognize it by, like a token's prefix
+func redactSecret(secret string) string {
+	if len(secret) <= 8 {
+		return "xxxxx"
+	}
+	return secret[:4] + "xxxxx"
+}
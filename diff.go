@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// what changed between a previous dump and this one, by path
+type manifestDiff struct {
+	Against string   `json:"against"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// reads the baseline -diff-against points at: a manifest file, a directory
+// holding one, or a previous dump without one, which is hashed on the spot.
+// A baseline that doesn't exist yet is empty, so everything counts as added.
+func loadBaseline(path string) (manifest, bool, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest{}, false, nil
+	}
+	if err != nil {
+		return manifest{}, false, err
+	}
+	if info.IsDir() {
+		recorded := filepath.Join(path, manifestName)
+		if _, err := os.Stat(recorded); err != nil {
+			m, err := buildManifest(path, recorded, "", "")
+			return m, true, err
+		}
+		path = recorded
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manifest{}, false, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return manifest{}, false, fmt.Errorf("%s is not a gget manifest: %w", path, err)
+	}
+	return m, true, nil
+}
+
+// compares two manifests by content, a file counts as changed when its hash
+// or symlink target differs
+func diffManifests(prev manifest, cur manifest) manifestDiff {
+	before := map[string]manifestFile{}
+	for _, f := range prev.Files {
+		before[f.Path] = f
+	}
+	d := manifestDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for _, f := range cur.Files {
+		old, ok := before[f.Path]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, f.Path)
+		case old.SHA256 != f.SHA256 || old.Link != f.Link:
+			d.Changed = append(d.Changed, f.Path)
+		}
+		delete(before, f.Path)
+	}
+	for path := range before {
+		d.Removed = append(d.Removed, path)
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d
+}
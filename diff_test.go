@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffManifests(t *testing.T) {
+	prev := manifest{Files: []manifestFile{
+		{Path: "README", SHA256: "aa"},
+		{Path: "gone", SHA256: "bb"},
+		{Path: "edited", SHA256: "cc"},
+		{Path: "link", Link: "README"},
+		{Path: "relinked", Link: "README"},
+	}}
+	cur := manifest{Files: []manifestFile{
+		{Path: "README", SHA256: "aa"},
+		{Path: "edited", SHA256: "dd"},
+		{Path: "link", Link: "README"},
+		{Path: "relinked", Link: "edited"},
+		{Path: "b-new", SHA256: "ee"},
+		{Path: "a-new", SHA256: "ff"},
+	}}
+	tests := []struct {
+		name      string
+		prev, cur manifest
+		want      manifestDiff
+	}{
+		{"no baseline", manifest{}, cur, manifestDiff{Added: []string{"README", "a-new", "b-new", "edited", "link", "relinked"}, Removed: []string{}, Changed: []string{}}},
+		{"unchanged", prev, prev, manifestDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}},
+		{"changes", prev, cur, manifestDiff{Added: []string{"a-new", "b-new"}, Removed: []string{"gone"}, Changed: []string{"edited", "relinked"}}},
+		{"everything removed", prev, manifest{}, manifestDiff{Added: []string{}, Removed: []string{"README", "edited", "gone", "link", "relinked"}, Changed: []string{}}},
+	}
+	for _, tt := range tests {
+		if got := diffManifests(tt.prev, tt.cur); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
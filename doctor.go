@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ttacon/chalk"
+)
+
+// checks that a dump could run: the daemon answers, the image builds and
+// git-dumper inside it starts. Prints a line per check and returns an error
+// when any of them failed.
+func Doctor(ctx context.Context, w io.Writer, entrypointBin string, opts BuildOptions) error {
+	pass := func(check string, detail string) {
+		fmt.Fprintf(w, "%s %s %s\n", chalk.Green.Color("ok  "), chalk.Bold.TextStyle(check), detail)
+	}
+	failed := func(check string, err error) error {
+		fmt.Fprintf(w, "%s %s %s\n", chalk.Red.Color("fail"), chalk.Bold.TextStyle(check), err)
+		return fmt.Errorf("%s check failed: %w", check, err)
+	}
+	runID := NewRunID()
+
+	cli, err := newClient(opts.Host, runID, opts.Trace)
+	if err != nil {
+		return failed("docker", wrapKind(ErrDockerUnreachable, err))
+	}
+	v, err := cli.ServerVersion(ctx)
+	if err != nil {
+		cli.Close()
+		return failed("docker", wrapKind(ErrDockerUnreachable, err))
+	}
+	info, err := cli.Info(ctx)
+	cli.Close()
+	if err != nil {
+		return failed("docker", wrapKind(ErrDockerUnreachable, err))
+	}
+	pass("docker", fmt.Sprintf("%s (api %s, %s/%s), %s, %d containers running", v.Version, v.APIVersion, v.Os, v.Arch, info.OperatingSystem, info.ContainersRunning))
+	if v.Os != "linux" {
+		return failed("docker", fmt.Errorf("the daemon runs %s containers, gget needs linux ones", v.Os))
+	}
+
+	img, err := NewDockerImage(ctx, runID, "", "", opts)
+	if err != nil {
+		return failed("build", err)
+	}
+	defer img.Client.Close()
+	pass("build", fmt.Sprintf("%.12s in %s", img.ID, img.BuildDuration.Round(time.Millisecond)))
+
+	out, err := img.RunCommand(ctx, []string{entrypointBin, "--help"}, nil)
+	if err != nil {
+		return failed(entrypointBin, err)
+	}
+	if !strings.Contains(strings.ToLower(out), "usage") {
+		return failed(entrypointBin, errors.New("--help printed no usage, is it git-dumper?"))
+	}
+	detail := "starts and prints its usage"
+	if version, err := img.DumperVersion(ctx); err == nil {
+		detail = "version " + version
+	}
+	pass(entrypointBin, detail)
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// kinds of failure callers can tell apart with errors.Is
+var (
+	ErrValidation        = errors.New("invalid options")
+	ErrDockerUnreachable = errors.New("docker daemon unreachable")
+	ErrBuildFailed       = errors.New("image build failed")
+	ErrRunFailed         = errors.New("dump failed")
+	ErrNothingRecovered  = errors.New("nothing recovered")
+	ErrOutOfScope        = errors.New("target out of scope")
+	ErrTargetGated       = errors.New("target requires authentication")
+)
+
+// the dump container exited non-zero, matches ErrRunFailed
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("git-dumper exited with status %d", e.Code)
+}
+
+func (e *ExitError) Unwrap() error {
+	return ErrRunFailed
+}
+
+// an error of a given kind, errors.Is matches both the kind and anything the
+// cause wraps
+type kindError struct {
+	kind  error
+	cause error
+}
+
+func wrapKind(kind error, cause error) error {
+	return &kindError{kind: kind, cause: cause}
+}
+
+func (e *kindError) Error() string {
+	return e.kind.Error() + ": " + e.cause.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.cause
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// git-dumper leaves the repository owned by the container's root user, so
+// every script opts out of git's ownership check before touching it
+const gitPrelude = `set -e
+git config --global --add safe.directory '*'
+`
+
+// a full or abbreviated commit sha, as -since-commit takes it
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+
+// pushes every recovered ref into a bare repository at /mirror. A dump can
+// come back without a usable HEAD, in which case the mirror's HEAD is pointed
+// at the first recovered branch so it can still be cloned. With a commit in
+// $1 it first prints "new <n>", the number of recovered commits not reachable
+// from it.
+const mirrorScript = gitPrelude + `git init -q --bare /mirror
+if ! git --git-dir=/git/.git rev-parse -q --verify HEAD >/dev/null; then
+	echo "warning: recovered repository has no valid HEAD" >&2
+fi
+if [ -n "$1" ]; then
+	if ! git --git-dir=/git/.git rev-parse -q --verify "$1^{commit}" >/dev/null; then
+		echo "commit $1 is not in the recovered history" >&2
+		exit 1
+	fi
+	if ! git --git-dir=/mirror rev-parse -q --verify "$1^{commit}" >/dev/null; then
+		echo "warning: the mirror doesn't have $1 yet, the history up to it is imported too" >&2
+	fi
+	echo "new $(git --git-dir=/git/.git rev-list --count --all "^$1")"
+fi
+git --git-dir=/git/.git push -q --mirror /mirror
+if ! git --git-dir=/mirror rev-parse -q --verify HEAD >/dev/null; then
+	ref=$(git --git-dir=/mirror for-each-ref --count=1 --format='%(refname)' refs/heads)
+	if [ -n "$ref" ]; then
+		git --git-dir=/mirror symbolic-ref HEAD "$ref"
+	fi
+fi
+git --git-dir=/mirror for-each-ref --format='%(objectname) %(refname)'
+`
+
+// checks out the branch named by $1, listing the recovered branches when it
+// doesn't exist
+const checkoutScript = gitPrelude + `cd /git
+if ! git show-ref -q --verify "refs/heads/$1"; then
+	echo "branch $1 was not recovered, available branches: $(git for-each-ref --format='%(refname:short)' refs/heads | tr '\n' ' ')" >&2
+	exit 1
+fi
+git checkout -q -f "$1"
+`
+
+// deletes everything but .git/objects, the working tree included
+const objectsOnlyScript = `set -e
+cd /git
+find . -mindepth 1 -maxdepth 1 ! -name .git -exec rm -rf {} +
+cd .git
+find . -mindepth 1 -maxdepth 1 ! -name objects -exec rm -rf {} +
+`
+
+// moves the contents of the directory $1 up into /git, hidden files included
+const flattenScript = `set -e
+cd /git
+find "./$1" -mindepth 1 -maxdepth 1 -exec mv {} . \;
+rmdir "./$1"
+`
+
+// empties the output directory of a failed dump
+const emptyOutputScript = `find /git -mindepth 1 -maxdepth 1 -exec rm -rf {} +`
+
+// prints "<problem> <file>" for every pack that is missing its counterpart or
+// fails verification, then a final "packs <n>" line with the number checked
+const verifyPacksScript = gitPrelude + `n=0
+if cd /git/.git/objects/pack 2>/dev/null; then
+	for idx in *.idx; do
+		[ -e "$idx" ] || continue
+		n=$((n+1))
+		if [ ! -e "${idx%.idx}.pack" ]; then
+			echo "missing ${idx%.idx}.pack"
+		elif ! git verify-pack "$idx" >/dev/null 2>&1; then
+			echo "corrupt ${idx%.idx}.pack"
+		fi
+	done
+	for pack in *.pack; do
+		[ -e "$pack" ] || continue
+		if [ ! -e "${pack%.pack}.idx" ]; then
+			n=$((n+1))
+			echo "missing ${pack%.pack}.idx"
+		fi
+	done
+fi
+echo "packs $n"
+`
+
+// walks history newest first so the first time a path shows up is its last
+// commit, then prints "<updated> <untouched>" where untouched counts tracked
+// files that have no history to take a time from
+const commitTimesScript = gitPrelude + `cd /git
+declare -A seen
+ts=""
+updated=0
+while IFS= read -r line; do
+	case "$line" in
+	@@*) ts=${line#@@} ;;
+	"") ;;
+	*)
+		if [ -z "${seen[$line]}" ]; then
+			seen[$line]=1
+			if [ -e "$line" ]; then
+				touch -h -d "@$ts" -- "$line"
+				updated=$((updated+1))
+			fi
+		fi
+		;;
+	esac
+done < <(git -c core.quotepath=off log --format=@@%ct --name-only HEAD)
+untouched=0
+while IFS= read -r f; do
+	if [ -z "${seen[$f]}" ]; then
+		untouched=$((untouched+1))
+	fi
+done < <(git -c core.quotepath=off ls-files)
+echo "$updated $untouched"
+`
+
+// creates or updates a bare repository at dir from the dumped objects and
+// returns the resulting refs as "<sha> <refname>". Given a since commit, which
+// must be in the recovered history, it also returns how many commits are
+// newer than it.
+func (di *DockerImage) Mirror(ctxroot context.Context, dir string, since string) ([]string, int, error) {
+	out, err := di.RunCommand(ctxroot, []string{"sh", "-c", mirrorScript, "sh", since}, []mount.Mount{
+		{
+			Type:     mount.TypeBind,
+			Source:   di.SourceDir,
+			Target:   "/git",
+			ReadOnly: true,
+		},
+		{
+			Type:   mount.TypeBind,
+			Source: dir,
+			Target: "/mirror",
+		},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	refs := strings.Split(strings.TrimSpace(out), "\n")
+	newCommits := 0
+	if since != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(refs[0], "new "))
+		if err != nil {
+			return nil, 0, fmt.Errorf("unexpected mirror output %q", refs[0])
+		}
+		newCommits = n
+		refs = refs[1:]
+	}
+	if len(refs) == 0 || refs[0] == "" {
+		return nil, 0, errors.New("no refs were recovered to mirror")
+	}
+	return refs, newCommits, nil
+}
+
+// replaces the working tree git-dumper checked out with the given branch
+func (di *DockerImage) Checkout(ctxroot context.Context, branch string) error {
+	_, err := di.RunCommand(ctxroot, []string{"sh", "-c", checkoutScript, "sh", branch}, []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: di.SourceDir,
+			Target: "/git",
+		},
+	})
+	return err
+}
+
+// narrows the dump down to its object store. It runs in a container since
+// the files may belong to the container's root user.
+func (di *DockerImage) ObjectsOnly(ctxroot context.Context) error {
+	_, err := di.RunCommand(ctxroot, []string{"sh", "-c", objectsOnlyScript}, []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: di.SourceDir,
+			Target: "/git",
+		},
+	})
+	return err
+}
+
+// moves a repository nested in SourceDir/name up into SourceDir, from a
+// container since the files belong to the container's root user
+func (di *DockerImage) Flatten(ctxroot context.Context, name string) error {
+	_, err := di.RunCommand(ctxroot, []string{"sh", "-c", flattenScript, "sh", name}, []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: di.SourceDir,
+			Target: "/git",
+		},
+	})
+	return err
+}
+
+// removes everything a failed dump left in SourceDir, from a container since
+// the files belong to the container's root user
+func (di *DockerImage) EmptyOutput(ctxroot context.Context) error {
+	_, err := di.RunCommand(ctxroot, []string{"sh", "-c", emptyOutputScript}, []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: di.SourceDir,
+			Target: "/git",
+		},
+	})
+	return err
+}
+
+// verifies every pack index against its pack and returns the number of packs
+// checked along with a description of each incomplete or corrupt one
+func (di *DockerImage) VerifyPacks(ctxroot context.Context) (int, []string, error) {
+	out, err := di.RunCommand(ctxroot, []string{"sh", "-c", verifyPacksScript}, []mount.Mount{
+		{
+			Type:     mount.TypeBind,
+			Source:   di.SourceDir,
+			Target:   "/git",
+			ReadOnly: true,
+		},
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	var (
+		n      int
+		broken []string
+	)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if strings.HasPrefix(line, "packs ") {
+			n, _ = strconv.Atoi(strings.TrimPrefix(line, "packs "))
+			continue
+		}
+		broken = append(broken, line)
+	}
+	return n, broken, nil
+}
+
+// sets the mtime of every checked out file to the time of the last commit
+// touching it, returning how many were updated and how many had no history
+func (di *DockerImage) CommitTimes(ctxroot context.Context) (int, int, error) {
+	out, err := di.RunCommand(ctxroot, []string{"bash", "-c", commitTimesScript}, []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: di.SourceDir,
+			Target: "/git",
+		},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	var updated, untouched int
+	if _, err := fmt.Sscan(out, &updated, &untouched); err != nil {
+		return 0, 0, fmt.Errorf("unexpected output setting commit times: %q", out)
+	}
+	return updated, untouched, nil
+}
+
+// counts the objects under .git/objects without git: every loose object plus
+// the total each pack index declares. A pack whose index didn't make it is
+// left out, VerifyPacks is what catches those.
+func CountObjects(output string) (int, error) {
+	objects := filepath.Join(output, ".git", "objects")
+	dirs, err := os.ReadDir(objects)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, d := range dirs {
+		// loose objects live in two hex digit fan-out directories
+		if !d.IsDir() || len(d.Name()) != 2 {
+			continue
+		}
+		if _, err := strconv.ParseUint(d.Name(), 16, 8); err != nil {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(objects, d.Name()))
+		if err != nil {
+			return 0, err
+		}
+		count += len(files)
+	}
+	idxs, err := filepath.Glob(filepath.Join(objects, "pack", "*.idx"))
+	if err != nil {
+		return 0, err
+	}
+	for _, idx := range idxs {
+		n, err := packIndexCount(idx)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// reads the object count from a version 2 pack index, the last entry of its
+// fan-out table
+func packIndexCount(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	header := make([]byte, 8+256*4)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if !bytes.Equal(header[:4], []byte("\xfftOc")) || binary.BigEndian.Uint32(header[4:8]) != 2 {
+		return 0, fmt.Errorf("%s is not a version 2 pack index", path)
+	}
+	return int(binary.BigEndian.Uint32(header[len(header)-4:])), nil
+}
@@ -7,6 +7,7 @@ require (
 	github.com/docker/docker v20.10.14+incompatible
 	github.com/opencontainers/image-spec v1.0.2
 	github.com/ttacon/chalk v0.0.0-20160626202418-22c06c80ed31
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// runs command through sh on the host once a dump has succeeded, describing
+// the dump in GGET_* environment variables. Its output is logged like the
+// container's.
+func RunHook(ctx context.Context, command string, timeout time.Duration, result Result, exitCode int) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	files := 0
+	if tree, err := readTree(result.OutputDir); err == nil {
+		files = tree.Files
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"GGET_RUN_ID="+result.RunID,
+		"GGET_URL="+redactURL(result.URL),
+		"GGET_OUTPUT="+result.OutputDir,
+		"GGET_EXIT_CODE="+strconv.Itoa(exitCode),
+		"GGET_FILES="+strconv.Itoa(files),
+	)
+	// the hook's own pipes rather than plain writers, so a timeout isn't held
+	// up by whatever it left running in the background still holding them
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		outR.Close()
+		outW.Close()
+		return err
+	}
+	defer outR.Close()
+	defer errR.Close()
+	cmd.Stdout = outW
+	cmd.Stderr = errW
+	err = cmd.Start()
+	outW.Close()
+	errW.Close()
+	if err != nil {
+		return fmt.Errorf("post hook: %w", err)
+	}
+	var wg sync.WaitGroup
+	for _, p := range []struct {
+		r   *os.File
+		tag string
+	}{{outR, "stdout"}, {errR, "stderr"}} {
+		wg.Add(1)
+		go func(r *os.File, tag string) {
+			defer wg.Done()
+			w := newEventWriter(result.RunID, "HOOK", tag, nil)
+			io.Copy(w, r)
+			w.Flush()
+		}(p.r, p.tag)
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		outR.Close()
+		errR.Close()
+	}
+	wg.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("post hook timed out after %s", timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("post hook: %w", err)
+	}
+	return nil
+}
+
+// hides any password in the url, a hook has no business seeing it
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// a repeatable string flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// a pattern with glob characters is matched against the whole host, anything
+// else matches the host itself and every subdomain of it
+func hostMatches(host string, pattern string) bool {
+	pattern = strings.ToLower(strings.TrimPrefix(pattern, "."))
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, _ := path.Match(pattern, host)
+		return ok
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// checks the host of rawURL against -deny-host and -allow-host, returning why
+// it's out of scope or "" when it may be dumped. A deny always wins.
+func checkScope(rawURL string, allow []string, deny []string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return "", fmt.Errorf("no host in %q", rawURL)
+	}
+	for _, pattern := range deny {
+		if hostMatches(host, pattern) {
+			return fmt.Sprintf("%s matches -deny-host %s", host, pattern), nil
+		}
+	}
+	if len(allow) == 0 {
+		return "", nil
+	}
+	for _, pattern := range allow {
+		if hostMatches(host, pattern) {
+			return "", nil
+		}
+	}
+	return fmt.Sprintf("%s is not covered by any -allow-host", host), nil
+}
+
+// checks -add-host entries are name:ip, as the daemon expects them in
+// HostConfig.ExtraHosts. host-gateway is the daemon's alias for the host.
+func parseExtraHosts(raw []string) ([]string, error) {
+	hosts := make([]string, 0, len(raw))
+	for _, entry := range raw {
+		name, ip, ok := strings.Cut(entry, ":")
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("expected name:ip, got %q", entry)
+		}
+		if ip != "host-gateway" && net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("%q in %q is not an ip address", ip, entry)
+		}
+		hosts = append(hosts, name+":"+ip)
+	}
+	return hosts, nil
+}
+
+// checks -dns entries are ip addresses
+func parseDNS(raw []string) ([]string, error) {
+	for _, ip := range raw {
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("%q is not an ip address", ip)
+		}
+	}
+	return raw, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// an image gget built, as `gget images` shows it
+type builtImage struct {
+	ID      string    `json:"id"`
+	Tags    []string  `json:"tags"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+	Version string    `json:"gget_version"`
+	// "" when the image doesn't say, as custom images may not
+	DumperVersion string `json:"git_dumper_version"`
+}
+
+// asks pip in the image which git-dumper it installed. It only knows when
+// the image installed it the way the embedded Dockerfile does.
+func (di *DockerImage) DumperVersion(ctx context.Context) (string, error) {
+	out, err := di.RunCommand(ctx, []string{"pip", "show", "git-dumper"}, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if version := strings.TrimPrefix(line, "Version: "); version != line {
+			return strings.TrimSpace(version), nil
+		}
+	}
+	return "", errors.New("pip show git-dumper printed no version")
+}
+
+// lists the images carrying labelImage, newest first. pip is asked for the
+// git-dumper version in a short-lived container per image, since the
+// Dockerfile installs whatever it resolves at build time.
+func ListImages(ctx context.Context, w io.Writer, host string, asJSON bool) error {
+	cli, err := newClient(host, "", nil)
+	if err != nil {
+		return wrapKind(ErrDockerUnreachable, err)
+	}
+	defer cli.Close()
+	summaries, err := cli.ImageList(ctx, types.ImageListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", labelImage)),
+	})
+	if err != nil {
+		return wrapKind(ErrDockerUnreachable, err)
+	}
+	images := make([]builtImage, 0, len(summaries))
+	for _, s := range summaries {
+		var tags []string
+		for _, tag := range s.RepoTags {
+			if tag != "<none>:<none>" {
+				tags = append(tags, tag)
+			}
+		}
+		dumper, _ := (&DockerImage{Client: cli, ID: s.ID}).DumperVersion(ctx)
+		images = append(images, builtImage{
+			ID:            strings.TrimPrefix(s.ID, "sha256:"),
+			Tags:          tags,
+			Size:          s.Size,
+			Created:       time.Unix(s.Created, 0),
+			Version:       s.Labels[labelVersion],
+			DumperVersion: dumper,
+		})
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Created.After(images[j].Created)
+	})
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(images)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE ID\tTAGS\tSIZE\tCREATED\tGGET VERSION\tGIT-DUMPER VERSION")
+	for _, img := range images {
+		tags := strings.Join(img.Tags, ",")
+		if tags == "" {
+			tags = "<none>"
+		}
+		dumper := img.DumperVersion
+		if dumper == "" {
+			dumper = "unknown"
+		}
+		fmt.Fprintf(tw, "%.12s\t%s\t%s\t%s\t%s\t%s\n", img.ID, tags, humanSize(img.Size), img.Created.Format("2006-01-02 15:04"), img.Version, dumper)
+	}
+	return tw.Flush()
+}
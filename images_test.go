@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+func TestListImages(t *testing.T) {
+	tests := []struct {
+		name string
+		// what `pip show git-dumper` prints, exits 1 when it's ""
+		pip  string
+		want string
+	}{
+		{"installed with pip", "Name: git-dumper\nVersion: 1.0.8\nSummary: A tool to dump a git repository from a website\n", "1.0.8"},
+		{"custom image", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created struct{ Image string }
+			cli, _ := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/images/json"):
+					fmt.Fprintf(w, `[{"Id":"sha256:feedface","RepoTags":["<none>:<none>"],"Size":1024,"Created":1700000000,"Labels":{%q:"true",%q:"v1.2.3"}}]`, labelImage, labelVersion)
+				case strings.HasSuffix(r.URL.Path, "/containers/create"):
+					json.NewDecoder(r.Body).Decode(&created)
+					fmt.Fprint(w, `{"Id":"helper"}`)
+				case strings.HasSuffix(r.URL.Path, "/wait"):
+					status := 0
+					if tt.pip == "" {
+						status = 1
+					}
+					fmt.Fprintf(w, `{"StatusCode":%d}`, status)
+				case strings.HasSuffix(r.URL.Path, "/logs"):
+					stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte(tt.pip))
+				default:
+					w.WriteHeader(http.StatusNoContent)
+				}
+			})
+			var out bytes.Buffer
+			if err := ListImages(context.Background(), &out, cli.DaemonHost(), true); err != nil {
+				t.Fatal(err)
+			}
+			var images []builtImage
+			if err := json.Unmarshal(out.Bytes(), &images); err != nil {
+				t.Fatal(err)
+			}
+			if len(images) != 1 || images[0].DumperVersion != tt.want || images[0].Version != "v1.2.3" {
+				t.Fatalf("got %+v, want git-dumper %q", images, tt.want)
+			}
+			if created.Image != "sha256:feedface" {
+				t.Fatalf("pip ran in %q, want the listed image", created.Image)
+			}
+
+			out.Reset()
+			if err := ListImages(context.Background(), &out, cli.DaemonHost(), false); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == "" {
+				want = "unknown"
+			}
+			if !strings.Contains(out.String(), "GIT-DUMPER VERSION") || !strings.Contains(out.String(), want) {
+				t.Fatalf("table doesn't show %s:\n%s", want, out.String())
+			}
+		})
+	}
+}
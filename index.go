@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// a file tracked by the exposed repository's .git/index
+type indexEntry struct {
+	Name string
+	Mode uint32
+	SHA  string
+}
+
+// files fetched by -only-index, enough to see what exists without any objects
+var indexFiles = []string{"HEAD", "packed-refs", "index"}
+
+// downloads HEAD, packed-refs and index into output/.git and returns what the
+// index tracks. Only the index is required, the others are best-effort.
+func FetchIndex(ctx context.Context, hc *http.Client, url string, output string, mode os.FileMode) ([]indexEntry, error) {
+	base := gitBaseURL(url)
+	gitDir := filepath.Join(output, ".git")
+	if err := os.MkdirAll(gitDir, mode); err != nil {
+		return nil, err
+	}
+	var index []byte
+	for _, name := range indexFiles {
+		data, err := fetch(ctx, hc, base+name)
+		if err != nil {
+			if name == "index" {
+				return nil, err
+			}
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(gitDir, name), data, 0644); err != nil {
+			return nil, err
+		}
+		if name == "index" {
+			index = data
+		}
+	}
+	return parseIndex(index)
+}
+
+// reads the entries of a version 2, 3 or 4 git index, see
+// Documentation/gitformat-index.txt in git
+func parseIndex(data []byte) ([]indexEntry, error) {
+	if len(data) < 12 || !bytes.Equal(data[:4], []byte("DIRC")) {
+		return nil, errors.New("not a git index")
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+	truncated := errors.New("index is truncated")
+	// count comes from the server, every entry takes at least 62 bytes
+	if uint64(count) > uint64(len(data)-12)/62 {
+		return nil, truncated
+	}
+
+	entries := make([]indexEntry, 0, count)
+	pos := 12
+	prev := ""
+	for i := uint32(0); i < count; i++ {
+		start := pos
+		// ctime, mtime, dev, ino, mode, uid, gid, size, sha, flags
+		if pos+62 > len(data) {
+			return nil, truncated
+		}
+		mode := binary.BigEndian.Uint32(data[pos+24 : pos+28])
+		sha := hex.EncodeToString(data[pos+40 : pos+60])
+		flags := binary.BigEndian.Uint16(data[pos+60 : pos+62])
+		pos += 62
+		if version >= 3 && flags&0x4000 != 0 {
+			pos += 2
+		}
+		var name string
+		if version == 4 {
+			// the name is the previous one with some bytes stripped off the end and a suffix added
+			strip, n := indexVarint(data[min(pos, len(data)):])
+			if n == 0 || strip > len(prev) {
+				return nil, truncated
+			}
+			pos += n
+			end := bytes.IndexByte(data[min(pos, len(data)):], 0)
+			if end < 0 {
+				return nil, truncated
+			}
+			name = prev[:len(prev)-strip] + string(data[pos:pos+end])
+			pos += end + 1
+		} else {
+			end := bytes.IndexByte(data[min(pos, len(data)):], 0)
+			if end < 0 {
+				return nil, truncated
+			}
+			name = string(data[pos : pos+end])
+			// entries are NUL padded to a multiple of 8 bytes
+			pos = start + (pos-start+end+8)&^7
+		}
+		entries = append(entries, indexEntry{Name: name, Mode: mode, SHA: sha})
+		prev = name
+	}
+	return entries, nil
+}
+
+// git's offset varint, each continuation adds one before shifting
+func indexVarint(b []byte) (int, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	val := int(b[0] & 0x7f)
+	n := 1
+	for b[n-1]&0x80 != 0 {
+		if n == len(b) {
+			return 0, 0
+		}
+		val = ((val + 1) << 7) | int(b[n]&0x7f)
+		n++
+	}
+	return val, n
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
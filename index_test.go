@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+const testSHA = "0123456789abcdef0123456789abcdef01234567"
+
+// builds an index with the given version and already encoded entries
+func testIndex(version uint32, count uint32, entries ...[]byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("DIRC")
+	binary.Write(&b, binary.BigEndian, version)
+	binary.Write(&b, binary.BigEndian, count)
+	for _, e := range entries {
+		b.Write(e)
+	}
+	return b.Bytes()
+}
+
+// encodes one entry. In version 4 name is the suffix after stripping strip
+// bytes off the previous name, before that it is padded to 8 bytes.
+func testEntry(version uint32, name string, strip int, extended bool) []byte {
+	var b bytes.Buffer
+	b.Write(make([]byte, 24))
+	binary.Write(&b, binary.BigEndian, uint32(0100644))
+	b.Write(make([]byte, 12))
+	sha, _ := hex.DecodeString(testSHA)
+	b.Write(sha)
+	flags := uint16(len(name))
+	if extended {
+		flags |= 0x4000
+	}
+	binary.Write(&b, binary.BigEndian, flags)
+	if extended {
+		b.Write([]byte{0, 0})
+	}
+	if version == 4 {
+		b.WriteByte(byte(strip))
+		b.WriteString(name)
+		b.WriteByte(0)
+		return b.Bytes()
+	}
+	b.WriteString(name)
+	b.Write(make([]byte, 8-b.Len()%8))
+	return b.Bytes()
+}
+
+func TestParseIndex(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		want  []string
+		fails bool
+	}{
+		{"not an index", []byte("nope"), nil, true},
+		{"header only", []byte("DIRC\x00\x00"), nil, true},
+		{"unsupported version", testIndex(5, 0), nil, true},
+		{"empty", testIndex(2, 0), []string{}, false},
+		{"huge count", []byte("DIRC\x00\x00\x00\x02\xff\xff\xff\xff"), nil, true},
+		{"count beyond entries", testIndex(2, 2, testEntry(2, "a", 0, false)), nil, true},
+		{"truncated entry", testIndex(2, 1, testEntry(2, "README.md", 0, false)[:40]), nil, true},
+		{"unterminated name", testIndex(2, 1, testEntry(2, "README.md", 0, false)[:62+4]), nil, true},
+		{"v2", testIndex(2, 2, testEntry(2, "README.md", 0, false), testEntry(2, "src/main.go", 0, false)), []string{"README.md", "src/main.go"}, false},
+		{"v3 extended", testIndex(3, 2, testEntry(3, "a.txt", 0, true), testEntry(3, "b.txt", 0, false)), []string{"a.txt", "b.txt"}, false},
+		{"v4", testIndex(4, 3, testEntry(4, "src/a.go", 0, false), testEntry(4, "b.go", 4, false), testEntry(4, "zz", 8, false)), []string{"src/a.go", "src/b.go", "zz"}, false},
+		{"v4 strips too much", testIndex(4, 2, testEntry(4, "a", 0, false), testEntry(4, "b", 5, false)), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseIndex(tt.data)
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", entries)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d", len(entries), len(tt.want))
+			}
+			for i, e := range entries {
+				if e.Name != tt.want[i] || e.SHA != testSHA || e.Mode != 0100644 {
+					t.Errorf("entry %d is %+v, want %s", i, e, tt.want[i])
+				}
+			}
+		})
+	}
+}
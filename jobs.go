@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// one entry of a -jobs file. Anything left out falls back to the command line.
+type job struct {
+	// names the entry in logs and the report, defaults to Output
+	Name string `json:"name" yaml:"name"`
+	URL  string `json:"url" yaml:"url"`
+	// subdirectory of -o, defaults to the url's host and path
+	Output string `json:"output" yaml:"output"`
+	Branch string `json:"branch" yaml:"branch"`
+	// git-dumper's -j, 0 keeps its default
+	Threads int `json:"threads" yaml:"threads"`
+	// "Name: value" headers, e.g. for authorization, passed to git-dumper's
+	// -H as the NAME=VALUE it takes
+	Headers []string `json:"headers" yaml:"headers"`
+}
+
+// reads the jobs in path, a json array or, for a .yaml or .yml file, a yaml
+// list
+func readJobs(path string) ([]job, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var jobs []job
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(file)
+		dec.KnownFields(true)
+		err = dec.Decode(&jobs)
+		// an empty file is no jobs, reported below like []
+		if err == io.EOF {
+			err = nil
+		}
+	default:
+		dec := json.NewDecoder(file)
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&jobs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return jobs, nil
+}
+
+// reads the jobs in path and turns them into targets under output and, when
+// it's set, mirror. Every entry is checked before anything runs.
+func jobTargets(path string, output string, mirror string) ([]target, error) {
+	jobs, err := readJobs(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%s has no jobs", path)
+	}
+	seen := map[string]int{}
+	targets := make([]target, 0, len(jobs))
+	for i, j := range jobs {
+		where := fmt.Sprintf("%s: job %d", path, i+1)
+		u, err := neturl.Parse(j.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s: url must be an http or https url, got %q", where, j.URL)
+		}
+		sub := j.Output
+		if sub == "" {
+			sub = targetDirName(u)
+		}
+		sub = filepath.Clean(sub)
+		if filepath.IsAbs(sub) || sub == "." || sub == ".." || strings.HasPrefix(sub, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s: output %q must be a subdirectory of -o", where, j.Output)
+		}
+		if prev, ok := seen[sub]; ok {
+			return nil, fmt.Errorf("%s: dumps into %s like job %d", where, sub, prev)
+		}
+		seen[sub] = i + 1
+		if j.Threads < 0 {
+			return nil, fmt.Errorf("%s: threads must not be negative, got %d", where, j.Threads)
+		}
+		t := target{
+			Name:   j.Name,
+			URL:    j.URL,
+			Output: filepath.Join(output, sub),
+			Branch: j.Branch,
+		}
+		if t.Name == "" {
+			t.Name = sub
+		}
+		if mirror != "" {
+			t.Mirror = filepath.Join(mirror, sub)
+		}
+		if j.Threads > 0 {
+			t.DumperArgs = append(t.DumperArgs, "-j", strconv.Itoa(j.Threads))
+		}
+		for _, h := range j.Headers {
+			name, value, ok := strings.Cut(h, ":")
+			name = strings.TrimSpace(name)
+			// git-dumper splits on the first =, so the name can't hold one
+			if !ok || name == "" || strings.ContainsAny(name, "= ") {
+				return nil, fmt.Errorf("%s: header %q must be \"Name: value\"", where, h)
+			}
+			t.DumperArgs = append(t.DumperArgs, "-H", name+"="+strings.TrimSpace(value))
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
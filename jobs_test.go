@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJobTargets(t *testing.T) {
+	tests := []struct {
+		name string
+		// jobs.json, or jobs.yaml when set
+		yaml bool
+		jobs string
+		want []target
+		// substring of the expected error, "" when it is valid
+		fails string
+	}{
+		{
+			name: "defaults",
+			jobs: `[{"url": "https://shop.example.com/.git"}]`,
+			want: []target{{Name: "shop.example.com", URL: "https://shop.example.com/.git", Output: "out/shop.example.com"}},
+		},
+		{
+			name: "overrides",
+			jobs: `[{"name": "app", "url": "https://example.com/app/.git", "output": "intranet", "branch": "develop", "threads": 20}]`,
+			want: []target{{Name: "app", URL: "https://example.com/app/.git", Output: "out/intranet", Branch: "develop", DumperArgs: []string{"-j", "20"}}},
+		},
+		{
+			name: "headers become NAME=VALUE",
+			jobs: `[{"url": "https://example.com/.git", "output": "x", "headers": ["Authorization: Bearer abc==", "X-Api-Key:1"]}]`,
+			want: []target{{Name: "x", URL: "https://example.com/.git", Output: "out/x", DumperArgs: []string{"-H", "Authorization=Bearer abc==", "-H", "X-Api-Key=1"}}},
+		},
+		{
+			name: "yaml",
+			yaml: true,
+			jobs: "- name: app\n  url: https://example.com/app/.git\n  threads: 2\n  headers: [\"Authorization: Bearer abc\"]\n",
+			want: []target{{Name: "app", URL: "https://example.com/app/.git", Output: "out/example.com_app", DumperArgs: []string{"-j", "2", "-H", "Authorization=Bearer abc"}}},
+		},
+		{name: "yaml unknown field", yaml: true, jobs: "- url: https://example.com/.git\n  thread: 2\n", fails: "not found"},
+		{name: "yaml empty", yaml: true, jobs: "", fails: "has no jobs"},
+		{name: "header without a colon", jobs: `[{"url": "https://example.com/.git", "headers": ["Authorization=Bearer abc"]}]`, fails: "must be"},
+		{name: "header name with =", jobs: `[{"url": "https://example.com/.git", "headers": ["a=b: c"]}]`, fails: "must be"},
+		{name: "empty", jobs: `[]`, fails: "has no jobs"},
+		{name: "unknown field", jobs: `[{"url": "https://example.com/.git", "thread": 2}]`, fails: "unknown field"},
+		{name: "not http", jobs: `[{"url": "ftp://example.com/.git"}]`, fails: "http or https"},
+		{name: "outside -o", jobs: `[{"url": "https://example.com/.git", "output": "../x"}]`, fails: "subdirectory of -o"},
+		{name: "same output", jobs: `[{"url": "https://example.com/a/.git", "output": "x"}, {"url": "https://example.com/b/.git", "output": "x"}]`, fails: "like job 1"},
+		{name: "negative threads", jobs: `[{"url": "https://example.com/.git", "threads": -1}]`, fails: "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "jobs.json")
+			if tt.yaml {
+				path = filepath.Join(filepath.Dir(path), "jobs.yaml")
+			}
+			if err := os.WriteFile(path, []byte(tt.jobs), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := jobTargets(path, "out", "")
+			if tt.fails != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.fails) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.fails)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
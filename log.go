@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/distribution/uuid"
+	"github.com/ttacon/chalk"
+)
+
+// prefix of the labels gget manages itself
+const labelPrefix = "com.gget."
+
+// label carrying the run id on every container gget creates
+const labelRunID = labelPrefix + "run-id"
+
+// labels on every image gget builds, marking it as gget's and recording
+// which version built it
+const (
+	labelImage   = labelPrefix + "image"
+	labelVersion = labelPrefix + "version"
+)
+
+// parses key=value labels from the command line. gget's own labels are
+// reserved, since prune and images rely on them meaning what gget put there.
+func parseLabels(raw []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, kv := range raw {
+		key, value, ok := strings.Cut(kv, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", kv)
+		}
+		if strings.HasPrefix(key, labelPrefix) {
+			return nil, fmt.Errorf("%s is reserved for gget's own labels", key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// short enough to read at the start of every line, long enough not to
+// collide between the dumps of one session
+func NewRunID() string {
+	return uuid.Generate().String()[:8]
+}
+
+// a single line of output, whether it came from the build stream, the run
+// or gget itself
+type Event struct {
+	RunID   string
+	Phase   string
+	Tag     string
+	Level   string
+	Message string
+}
+
+const (
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// receives every event gget produces
+type Logger interface {
+	Log(e Event)
+}
+
+// the default colored "[runid] <PHASE> <tag> message" output
+type TextLogger struct {
+	Out io.Writer
+}
+
+func (t *TextLogger) Log(e Event) {
+	runID := chalk.Dim.TextStyle("[" + e.RunID + "]")
+	switch e.Level {
+	case LevelError:
+		fmt.Fprintf(t.Out, "%s <%s> <%s> %s\n", runID, chalk.Red.Color(e.Phase), chalk.Red.Color("error"), chalk.Underline.TextStyle(chalk.Red.Color(e.Message)))
+	case LevelWarning:
+		fmt.Fprintf(t.Out, "%s <%s> <%s> %s\n", runID, chalk.Yellow.Color(e.Phase), chalk.Yellow.Color("warning"), chalk.White.Color(e.Message))
+	default:
+		fmt.Fprintf(t.Out, "%s <%s> <%s> %s\n", runID, chalk.Green.Color(e.Phase), chalk.Yellow.Color(e.Tag), chalk.White.Color(e.Message))
+	}
+}
+
+// where events go unless something else asks for them
+var logger Logger = &TextLogger{Out: os.Stdout}
+
+// drops the build and container output a Logger would otherwise print line by
+// line, keeping gget's own messages, warnings and errors
+type summaryLogger struct {
+	Next Logger
+}
+
+func (s *summaryLogger) Log(e Event) {
+	if e.Level == LevelInfo {
+		switch e.Tag {
+		case "stream", "aux", "stdout", "stderr", "heartbeat":
+			return
+		}
+	}
+	s.Next.Log(e)
+}
+
+// name of the file -save-log writes into each target's output directory
+const runLogName = "gget.log"
+
+// keeps an uncolored copy of one run's events on their way to Next, for
+// -save-log
+type runLog struct {
+	Next  Logger
+	RunID string
+	mu    sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (r *runLog) Log(e Event) {
+	if e.RunID == r.RunID {
+		r.mu.Lock()
+		fmt.Fprintf(&r.buf, "%s [%s] <%s> <%s> %s\n", time.Now().Format(time.RFC3339), e.RunID, e.Phase, e.Tag, e.Message)
+		r.mu.Unlock()
+	}
+	r.Next.Log(e)
+}
+
+// writes what was logged, and the error the run ended with if any, into
+// output. Nothing is written when output was cleaned up.
+func (r *runLog) Save(output string, runErr error) error {
+	if _, err := os.Stat(output); os.IsNotExist(err) {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if runErr != nil {
+		fmt.Fprintf(&r.buf, "%s [%s] <RUN> <error> %v\n", time.Now().Format(time.RFC3339), r.RunID, runErr)
+	}
+	return os.WriteFile(filepath.Join(output, runLogName), r.buf.Bytes(), 0644)
+}
+
+func logInfo(runID string, phase string, tag string, msg string) {
+	logger.Log(Event{RunID: runID, Phase: phase, Tag: tag, Level: LevelInfo, Message: msg})
+}
+
+func logWarning(runID string, phase string, msg string) {
+	logger.Log(Event{RunID: runID, Phase: phase, Tag: "warning", Level: LevelWarning, Message: msg})
+}
+
+func logError(runID string, phase string, msg string) {
+	logger.Log(Event{RunID: runID, Phase: phase, Tag: "error", Level: LevelError, Message: msg})
+}
+
+// turns container output into one event per line
+type eventWriter struct {
+	runID string
+	phase string
+	tag   string
+	buf   []byte
+	// told about every line, may be nil
+	hb *heartbeat
+}
+
+func newEventWriter(runID string, phase string, tag string, hb *heartbeat) *eventWriter {
+	return &eventWriter{runID: runID, phase: phase, tag: tag, hb: hb}
+}
+
+func (e *eventWriter) Write(b []byte) (int, error) {
+	e.buf = append(e.buf, b...)
+	for {
+		i := bytes.IndexByte(e.buf, '\n')
+		if i < 0 {
+			break
+		}
+		logInfo(e.runID, e.phase, e.tag, string(e.buf[:i]))
+		if e.hb != nil {
+			e.hb.Touch()
+		}
+		e.buf = e.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// emits whatever is left of an unterminated last line
+func (e *eventWriter) Flush() {
+	if len(e.buf) > 0 {
+		logInfo(e.runID, e.phase, e.tag, string(e.buf))
+		e.buf = nil
+	}
+}
+
+// logs a "still running" line whenever nothing else was logged for interval,
+// so a dump stuck on one large object doesn't look hung
+type heartbeat struct {
+	runID    string
+	interval time.Duration
+	started  time.Time
+	last     int64
+	done     chan struct{}
+}
+
+func startHeartbeat(runID string, interval time.Duration) *heartbeat {
+	h := &heartbeat{
+		runID:    runID,
+		interval: interval,
+		started:  time.Now(),
+		done:     make(chan struct{}),
+	}
+	h.Touch()
+	if interval > 0 {
+		go h.run()
+	}
+	return h
+}
+
+func (h *heartbeat) run() {
+	// checking a few times per interval keeps the line close to on time
+	tick := h.interval / 4
+	if tick <= 0 {
+		tick = h.interval
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, atomic.LoadInt64(&h.last))) >= h.interval {
+				logInfo(h.runID, "RUN", "heartbeat", fmt.Sprintf("still running (%s elapsed)", now.Sub(h.started).Round(time.Second)))
+				h.Touch()
+			}
+		}
+	}
+}
+
+// records that something was just logged
+func (h *heartbeat) Touch() {
+	atomic.StoreInt64(&h.last, time.Now().UnixNano())
+}
+
+func (h *heartbeat) Stop() {
+	close(h.done)
+}
@@ -1,33 +1,56 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"errors"
+	"crypto/tls"
 	"embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"net"
+	"net/http"
+	neturl "net/url"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/docker/distribution/uuid"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
-	"github.com/ttacon/chalk"
 )
 
 //go:embed Dockerfile.tar.gz
 var f embed.FS
 
-// Write json response to stdout
+// subcommands and their descriptions, anything else is treated as flags
+var subcommands = map[string]string{
+	"completion": "print a completion script for bash, zsh or fish",
+	"doctor":     "check that the daemon, the image build and git-dumper work",
+	"images":     "list the images gget has built",
+	"prune":      "remove containers and volumes left behind by interrupted runs, and built images",
+}
+
+// Decodes the json build stream into events
 type ErrorDetail struct {
 	Message string `json:"message"`
 }
@@ -35,34 +58,56 @@ type Aux struct {
 	ID string `json:"ID"`
 }
 type DockerJSONWriter struct {
+	RunID  string `json:"-"`
+	Events Logger `json:"-"`
 	Stream string `json:"stream"`
 	Aux    Aux    `json:"aux"`
 
+	// build steps seen and how many of them the daemon took from its cache
+	Steps       int `json:"-"`
+	CachedSteps int `json:"-"`
+
 	ErrorDetail ErrorDetail `json:"errorDetail"`
 }
 
 func (d *DockerJSONWriter) TagExists(tag string) bool {
 	return strings.Trim(tag, "\n") != ""
 }
-func (d *DockerJSONWriter) Print(phase string, r io.ReadCloser) error {
+
+// decodes every message in r and hands what it carries to d.Events, or the
+// default logger when that's unset
+func (d *DockerJSONWriter) Emit(phase string, r io.ReadCloser) error {
+	events := d.Events
+	if events == nil {
+		events = logger
+	}
 
 	j := json.NewDecoder(r)
-	for err := j.Decode(d); err != io.EOF; err = j.Decode(d) {
+	for {
+		// messages without a stream would otherwise repeat the previous one
+		d.Stream = ""
+		err := j.Decode(d)
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return err
 		}
 
-		switch phase {
-		case "BUILD":
-			if d.TagExists(d.Stream) {
-				fmt.Printf("<%s> <%s> %s\n", chalk.Green.Color(phase), chalk.Yellow.Color("stream"), chalk.White.Color(d.Stream))
-			}
-			if d.TagExists(d.Aux.ID) {
-				fmt.Printf("<%s> <%s> %s\n", chalk.Green.Color(phase), chalk.Yellow.Color("aux"), chalk.White.Color(d.Aux.ID))
-			}
-			if d.TagExists(d.ErrorDetail.Message) {
-				fmt.Printf("<%s> <%s> %s\n", chalk.Red.Color(phase), chalk.Red.Color("error"), chalk.Underline.TextStyle(chalk.Red.Color(d.ErrorDetail.Message)))
-			}
+		if strings.HasPrefix(d.Stream, "Step ") {
+			d.Steps++
+		}
+		if strings.TrimSpace(d.Stream) == "---> Using cache" {
+			d.CachedSteps++
+		}
+		if d.TagExists(d.Stream) {
+			events.Log(Event{RunID: d.RunID, Phase: phase, Tag: "stream", Level: LevelInfo, Message: d.Stream})
+		}
+		if d.TagExists(d.Aux.ID) {
+			events.Log(Event{RunID: d.RunID, Phase: phase, Tag: "aux", Level: LevelInfo, Message: d.Aux.ID})
+		}
+		if d.TagExists(d.ErrorDetail.Message) {
+			events.Log(Event{RunID: d.RunID, Phase: phase, Tag: "error", Level: LevelError, Message: d.ErrorDetail.Message})
 		}
 	}
 	return nil
@@ -70,165 +115,2296 @@ func (d *DockerJSONWriter) Print(phase string, r io.ReadCloser) error {
 
 type DockerImage struct {
 	ID          string
-	SourceDir 	string
-	URL 		string
+	RunID       string
+	SourceDir   string
+	URL         string
+	Socks5      string
+	Name        string
+	ExitCode    int
 	ContextRoot context.Context
 	Client      *client.Client
 	JSON        *DockerJSONWriter
+	// how long the build took and whether every step came from the cache
+	BuildDuration time.Duration
+	Reused        bool
+
+	// where SourceDir is mounted and git-dumper writes inside the container
+	ContainerPath string
+	// git-dumper, or whatever a custom image installed it as
+	EntrypointBin string
+	// stream logs live rather than reading them after the container exits
+	Follow bool
+	// git-dumper's --retry and --timeout (seconds), 0 keeps its defaults
+	RequestRetries int
+	RequestTimeout int
+	// extra options passed through to git-dumper after --
+	DumperArgs []string
+	// how long to wait for the log stream to start, 0 waits forever
+	AttachTimeout time.Duration
+	// quiet period before logging that the dump is still running, 0 disables
+	Heartbeat time.Duration
+	// VolumeModeBind or VolumeModeNamed, VolumeName is set once created
+	VolumeMode string
+	VolumeName string
+	// leave the container's volumes, including the named output volume, in place
+	KeepVolumes bool
+	// "host" opts out of the daemon's userns-remap, "" keeps its default
+	UsernsMode string
+	// extra environment for the dump container, like proxies from the docker config
+	Env []string
+	// user labels for every container, never overriding gget's own
+	Labels map[string]string
+	// how long a dump that's cut short gets to exit after SIGTERM before it's
+	// killed, 0 kills it straight away
+	KillGrace time.Duration
+	// run the image's own entrypoint with the dumper's arguments as its
+	// command, instead of replacing it with EntrypointBin
+	KeepEntrypoint bool
+	// name:ip entries for the container's /etc/hosts and the resolvers it
+	// uses, for names only internal dns knows
+	ExtraHosts []string
+	DNS        []string
+	// host paths of a client certificate and its key for mutual tls, mounted
+	// read-only into the dump container
+	ClientCert string
+	ClientKey  string
+}
+
+// the user's labels plus the run id gget tracks its containers by
+func (di *DockerImage) containerLabels() map[string]string {
+	labels := map[string]string{}
+	for k, v := range di.Labels {
+		labels[k] = v
+	}
+	labels[labelRunID] = di.RunID
+	return labels
+}
+
+const (
+	VolumeModeBind  = "bind"
+	VolumeModeNamed = "named"
+)
+
+func (di *DockerImage) Entrypoint() []string {
+	entrypoint := []string{di.EntrypointBin}
+	if di.ClientCert != "" {
+		entrypoint = []string{"python", "-c", clientCertScript}
+	}
+	if di.Socks5 != "" {
+		entrypoint = append(entrypoint, "--proxy", "socks5:"+di.Socks5)
+	}
+	if di.RequestRetries > 0 {
+		entrypoint = append(entrypoint, "--retry", strconv.Itoa(di.RequestRetries))
+	}
+	if di.RequestTimeout > 0 {
+		entrypoint = append(entrypoint, "--timeout", strconv.Itoa(di.RequestTimeout))
+	}
+	entrypoint = append(entrypoint, di.DumperArgs...)
+	return append(entrypoint, di.URL, di.ContainerPath)
+}
+
+// the dump container's entrypoint, command and environment. With
+// KeepEntrypoint the image's entrypoint stays and gets everything but
+// EntrypointBin as its command, and the url and output path as GGET_URL and
+// GGET_OUTPUT for images that would rather read those.
+func (di *DockerImage) command() ([]string, []string, []string) {
+	if !di.KeepEntrypoint {
+		return di.Entrypoint(), nil, di.Env
+	}
+	env := append(append([]string{}, di.Env...), "GGET_URL="+di.URL, "GGET_OUTPUT="+di.ContainerPath)
+	return nil, di.Entrypoint()[1:], env
+}
+
+// git-dumper options that consume the argument after them
+var dumperValueOptions = map[string]bool{
+	"--proxy":                    true,
+	"--client-cert-p12":          true,
+	"--client-cert-p12-password": true,
+	"-j":                         true,
+	"--jobs":                     true,
+	"-r":                         true,
+	"--retry":                    true,
+	"-t":                         true,
+	"--timeout":                  true,
+	"-u":                         true,
+	"--user-agent":               true,
+	"-H":                         true,
+	"--header":                   true,
+}
+
+// gget supplies git-dumper's url and directory itself, a stray positional in
+// the passthrough would be taken as one of them and send the dump somewhere
+// the mount isn't
+func validateDumperArgs(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return fmt.Errorf("unexpected argument %q after --, gget passes the url and output directory to git-dumper itself, use -u and -o instead", arg)
+		}
+		if strings.HasPrefix(arg, "--header=") {
+			value := strings.TrimPrefix(arg, "--header=")
+			if _, _, ok := splitDumperHeader(value); !ok {
+				return fmt.Errorf("git-dumper's --header takes NAME=VALUE, got %q", value)
+			}
+			continue
+		}
+		if dumperValueOptions[arg] {
+			if i+1 == len(args) {
+				return fmt.Errorf("git-dumper option %s needs a value", arg)
+			}
+			i++
+			if arg == "-H" || arg == "--header" {
+				if _, _, ok := splitDumperHeader(args[i]); !ok {
+					return fmt.Errorf("git-dumper's %s takes NAME=VALUE, got %q", arg, args[i])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// splits a git-dumper -H value the way git-dumper does, on the first =
+func splitDumperHeader(h string) (string, string, bool) {
+	name, value, ok := strings.Cut(h, "=")
+	name = strings.TrimSpace(name)
+	return name, strings.TrimSpace(value), ok && name != ""
+}
+
+// the headers git-dumper will send, from the -H and --header options in
+// already validated args, so the probe can send them too
+func dumperHeaders(args []string) http.Header {
+	header := http.Header{}
+	for i := 0; i < len(args); i++ {
+		var value string
+		switch arg := args[i]; {
+		case strings.HasPrefix(arg, "--header="):
+			value = strings.TrimPrefix(arg, "--header=")
+		case (arg == "-H" || arg == "--header") && i+1 < len(args):
+			i++
+			value = args[i]
+		case dumperValueOptions[arg]:
+			i++
+			continue
+		default:
+			continue
+		}
+		if name, value, ok := splitDumperHeader(value); ok {
+			header.Set(name, value)
+		}
+	}
+	return header
+}
+
+// a SOCKS5 greeting offering no authentication, the proxy has to answer with version 5
+const socks5CheckScript = `import socket, sys
+s = socket.create_connection((sys.argv[1], int(sys.argv[2])), 5)
+s.sendall(b"\x05\x01\x00")
+if s.recv(2)[:1] != b"\x05":
+    sys.exit("not a SOCKS5 proxy")
+`
+
+// checks the SOCKS5 proxy is reachable from inside a container, where
+// loopback addresses on the host are not
+func (di *DockerImage) CheckSocks5(ctxroot context.Context) error {
+	host, port, err := net.SplitHostPort(di.Socks5)
+	if err != nil {
+		return err
+	}
+	_, err = di.runCommand(ctxroot, []string{"python", "-c", socks5CheckScript, host, port}, &container.HostConfig{
+		ExtraHosts: di.ExtraHosts,
+		DNS:        di.DNS,
+	})
+	if err != nil {
+		lines := strings.Split(err.Error(), "\n")
+		return fmt.Errorf("socks5 proxy %s is unreachable from the container: %s", di.Socks5, lines[len(lines)-1])
+	}
+	return nil
+}
+
+// how many suffixed names CreateContainer tries when -name is taken
+const maxNameSuffix = 5
+
+// where the client certificate and key are mounted in the dump container
+const (
+	clientCertPath = "/gget-tls/client.crt"
+	clientKeyPath  = "/gget-tls/client.key"
+)
+
+// git-dumper has no option for a client certificate, so with one it runs from
+// this wrapper, which sets it on every requests session. Workers are forked so
+// they inherit the patch instead of starting a fresh interpreter.
+const clientCertScript = `import multiprocessing, sys
+import requests
+multiprocessing.set_start_method("fork", force=True)
+init = requests.Session.__init__
+def session_init(self, *args, **kwargs):
+    init(self, *args, **kwargs)
+    self.cert = ("` + clientCertPath + `", "` + clientKeyPath + `")
+requests.Session.__init__ = session_init
+from git_dumper import main
+sys.argv[0] = "git-dumper"
+sys.exit(main())
+`
+
+// the read-only mounts of the client certificate and key, if any
+func (di *DockerImage) certMounts() []mount.Mount {
+	if di.ClientCert == "" {
+		return nil
+	}
+	return []mount.Mount{
+		{Type: mount.TypeBind, Source: di.ClientCert, Target: clientCertPath, ReadOnly: true},
+		{Type: mount.TypeBind, Source: di.ClientKey, Target: clientKeyPath, ReadOnly: true},
+	}
+}
+
+// binds SourceDir directly, or in named mode a volume that is copied out
+// once the dump is done
+func (di *DockerImage) outputMount() mount.Mount {
+	if di.VolumeMode == VolumeModeNamed {
+		return mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: di.VolumeName,
+			Target: di.ContainerPath,
+		}
+	}
+	return mount.Mount{
+		Type:   mount.TypeBind,
+		Source: di.SourceDir,
+		Target: di.ContainerPath,
+	}
 }
 
 func (di *DockerImage) CreateContainer(ctxroot context.Context, chID chan string) error {
 	defer close(chID)
-	body, err := di.Client.ContainerCreate(
-		ctxroot,
-		&container.Config{
-			Image:        di.ID,
-			AttachStdout: true,
-			AttachStderr: true,
-			Entrypoint:   []string{"git-dumper", di.URL, "/git"},
-		},
-		&container.HostConfig{
-			Mounts: []mount.Mount{
-				{
-					Type:   mount.TypeBind,
-					Source: di.SourceDir,
-					Target: "/git",
-				},
+	// a signal may have arrived while setting up, don't start anything new
+	if err := ctxroot.Err(); err != nil {
+		return err
+	}
+	if di.VolumeMode == VolumeModeNamed {
+		vol, err := di.Client.VolumeCreate(ctxroot, volume.VolumeCreateBody{
+			Name: "gget-" + di.RunID,
+			Labels: map[string]string{
+				labelRunID: di.RunID,
 			},
-		},
-		&network.NetworkingConfig{},
-		&v1.Platform{
-			OS: "linux",
-		},
+		})
+		if err != nil {
+			return wrapKind(ErrRunFailed, err)
+		}
+		di.VolumeName = vol.Name
+	}
+	name := di.Name
+	if name == "" {
 		//random uuid string for docker container name
-		uuid.Generate().String(),
+		name = uuid.Generate().String()
+	}
+	var (
+		body container.ContainerCreateCreatedBody
+		err  error
 	)
-
+	entrypoint, cmd, env := di.command()
+	// a stale container from an earlier run can hold the requested name,
+	// so fall back to name-1, name-2, ... before giving up
+	for attempt := 0; attempt <= maxNameSuffix; attempt++ {
+		if attempt > 0 {
+			name = fmt.Sprintf("%s-%d", di.Name, attempt)
+		}
+		body, err = di.Client.ContainerCreate(
+			ctxroot,
+			&container.Config{
+				Image:        di.ID,
+				AttachStdout: true,
+				AttachStderr: true,
+				Entrypoint:   entrypoint,
+				Cmd:          cmd,
+				Env:          env,
+				Labels:       di.containerLabels(),
+			},
+			&container.HostConfig{
+				Mounts:     append([]mount.Mount{di.outputMount()}, di.certMounts()...),
+				UsernsMode: container.UsernsMode(di.UsernsMode),
+				ExtraHosts: di.ExtraHosts,
+				DNS:        di.DNS,
+			},
+			&network.NetworkingConfig{},
+			&v1.Platform{
+				OS: "linux",
+			},
+			name,
+		)
+		if di.Name == "" || !errdefs.IsConflict(err) {
+			break
+		}
+	}
 
 	if err != nil {
-		return err
+		di.removeVolume()
+		return wrapKind(ErrRunFailed, err)
+	}
+	if di.Name != "" {
+		logInfo(di.RunID, "RUN", "name", name)
 	}
 
-	chID <- body.ID
+	select {
+	case chID <- body.ID:
+	case <-ctxroot.Done():
+		// nobody will run the container, so don't leave it behind
+		di.Client.ContainerRemove(context.Background(), body.ID, di.removeOptions())
+		di.removeVolume()
+		return ctxroot.Err()
+	}
 	return nil
 }
+
+// removes the named output volume of a container that never ran, it holds
+// nothing worth keeping
+func (di *DockerImage) removeVolume() {
+	if di.VolumeName != "" {
+		di.Client.VolumeRemove(context.Background(), di.VolumeName, true)
+	}
+}
+
+// the named output volume is removed on its own once it has been copied out,
+// taking it down with the container would lose the dump
+func (di *DockerImage) removeOptions() types.ContainerRemoveOptions {
+	return types.ContainerRemoveOptions{
+		RemoveVolumes: !di.KeepVolumes && di.VolumeMode != VolumeModeNamed,
+		Force:         true,
+	}
+}
+
 func (di *DockerImage) RunContainer(ctxroot context.Context, id string) error {
-	fmt.Printf("<%s> <%s> %s\n", chalk.Green.Color("RUN"), chalk.Yellow.Color("ID"), chalk.White.Color("Running container "+id))
+	logInfo(di.RunID, "RUN", "ID", "Running container "+id)
+	// set once the container has exited on its own
+	exited := false
+	// ctxroot may already be canceled by a signal, the container still has to go
+	defer func() {
+		if !exited && di.KillGrace > 0 {
+			di.stopGracefully(id)
+		}
+		di.Client.ContainerRemove(context.Background(), id, di.removeOptions())
+		if di.VolumeName == "" {
+			return
+		}
+		if di.KeepVolumes {
+			logInfo(di.RunID, "RUN", "volume", "keeping "+di.VolumeName)
+			return
+		}
+		di.Client.VolumeRemove(context.Background(), di.VolumeName, true)
+	}()
 
 	err := di.Client.ContainerStart(ctxroot, id, types.ContainerStartOptions{})
 	if err != nil {
-		return err
+		return wrapKind(ErrRunFailed, err)
+	}
+	hb := startHeartbeat(di.RunID, di.Heartbeat)
+	defer hb.Stop()
+	// without following, the logs are read once the container is done
+	exitCode := 0
+	if !di.Follow {
+		exitCode, err = di.WaitContainer(ctxroot, id)
+		if err != nil {
+			return wrapKind(ErrRunFailed, err)
+		}
+		exited = true
+	}
+
+	// a stalled daemon can leave the log stream hanging forever, give up if
+	// nothing arrives within AttachTimeout
+	ctxlogs, cancel := context.WithCancel(ctxroot)
+	defer cancel()
+	var (
+		stalled int32
+		timer   *time.Timer
+	)
+	if di.AttachTimeout > 0 {
+		timer = time.AfterFunc(di.AttachTimeout, func() {
+			atomic.StoreInt32(&stalled, 1)
+			cancel()
+		})
+		defer timer.Stop()
 	}
-	rc, err := di.Client.ContainerLogs(ctxroot, id, types.ContainerLogsOptions{
-		Follow:     true,
+	rc, err := di.Client.ContainerLogs(ctxlogs, id, types.ContainerLogsOptions{
+		Follow:     di.Follow,
 		ShowStdout: true,
 		ShowStderr: true,
 	})
-	if err != nil {
+	if err == nil {
+		stdout := newEventWriter(di.RunID, "RUN", "stdout", hb)
+		stderr := newEventWriter(di.RunID, "RUN", "stderr", hb)
+		stdcopy.StdCopy(stdout, stderr, &stopOnRead{r: rc, timer: timer})
+		stdout.Flush()
+		stderr.Flush()
+	}
+	if atomic.LoadInt32(&stalled) == 1 {
+		return wrapKind(ErrRunFailed, fmt.Errorf("no output from container %s within %s", id, di.AttachTimeout))
+	}
+	if err := ctxroot.Err(); err != nil {
 		return err
 	}
-	io.Copy(os.Stdout, rc)
-	di.Client.ContainerRemove(ctxroot, id, types.ContainerRemoveOptions{
-		RemoveVolumes: true,
-		Force:         true,
-	})
-
 	if err != nil {
-		return err
+		return wrapKind(ErrRunFailed, err)
+	}
+
+	// the log stream ending doesn't mean the container has, wait for it
+	if di.Follow {
+		exitCode, err = di.WaitContainer(ctxroot, id)
+		if err != nil {
+			return wrapKind(ErrRunFailed, err)
+		}
+	}
+	exited = true
+	di.ExitCode = exitCode
+	// copy out even a failed dump, partial output is still worth having
+	if di.VolumeName != "" {
+		if err := di.copyOut(ctxroot, id); err != nil {
+			return wrapKind(ErrRunFailed, err)
+		}
+	}
+	if exitCode != 0 {
+		return &ExitError{Code: exitCode}
 	}
 	return nil
 }
 
-// builds from embedded dockerfile
-func NewDockerImage(ctxroot context.Context, url string, sourcedir string) (*DockerImage, error) {
-	client, err := client.NewClientWithOpts(client.FromEnv)
+// sends the dump SIGTERM and gives it KillGrace to exit before the daemon
+// kills it, so it can finish writing what it has. A dump in a named volume is
+// copied out afterwards, since nothing of it would reach the host otherwise.
+func (di *DockerImage) stopGracefully(id string) {
+	// the run is already being torn down, don't let a stuck daemon hold it up
+	ctx, cancel := context.WithTimeout(context.Background(), di.KillGrace+30*time.Second)
+	defer cancel()
+	grace := di.KillGrace
+	logInfo(di.RunID, "RUN", "stop", fmt.Sprintf("stopping container %s, killing it after %s", id, grace))
+	if err := di.Client.ContainerStop(ctx, id, &grace); err != nil {
+		logWarning(di.RunID, "RUN", fmt.Sprintf("stopping container %s: %v", id, err))
+		return
+	}
+	if di.VolumeName == "" {
+		return
+	}
+	if err := di.copyOut(ctx, id); err != nil {
+		logWarning(di.RunID, "RUN", "copying out the partial dump: "+err.Error())
+		return
+	}
+	logInfo(di.RunID, "RUN", "salvage", "copied the partial dump out of "+di.VolumeName)
+}
+
+// blocks until the container stops and returns its exit code. The daemon
+// reports either on the status channel, possibly with an error of its own
+// alongside the code, or on the error channel when waiting itself failed.
+func (di *DockerImage) WaitContainer(ctx context.Context, id string) (int, error) {
+	chStatus, chErr := di.Client.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+	select {
+	case err := <-chErr:
+		if ctx.Err() != nil {
+			return -1, fmt.Errorf("waiting for container %s: %w", id, err)
+		}
+		// some docker-compatible daemons don't implement wait properly
+		logWarning(di.RunID, "RUN", fmt.Sprintf("waiting for container %s failed (%v), polling its state instead", id, err))
+		return di.pollContainer(ctx, id)
+	case status := <-chStatus:
+		if status.Error != nil && status.Error.Message != "" {
+			return int(status.StatusCode), fmt.Errorf("container %s exited with %d: %s", id, status.StatusCode, status.Error.Message)
+		}
+		return int(status.StatusCode), nil
+	}
+}
+
+// how often pollContainer inspects a container that is still running
+const pollInterval = 500 * time.Millisecond
+
+// WaitContainer's fallback, inspects the container until it has stopped
+func (di *DockerImage) pollContainer(ctx context.Context, id string) (int, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		inspect, err := di.Client.ContainerInspect(ctx, id)
+		if err != nil {
+			return -1, fmt.Errorf("inspecting container %s: %w", id, err)
+		}
+		if state := inspect.State; state != nil && !state.Running && !state.Restarting && state.Status != "created" {
+			if state.Error != "" {
+				return state.ExitCode, fmt.Errorf("container %s exited with %d: %s", id, state.ExitCode, state.Error)
+			}
+			return state.ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Docker Desktop runs the daemon in a VM that only sees the host paths shared
+// with it, anything else bind-mounts as an empty directory
+func (di *DockerImage) IsDockerDesktop(ctxroot context.Context) (bool, error) {
+	info, err := di.Client.Info(ctxroot)
 	if err != nil {
-		log.Fatal(err)
+		return false, err
 	}
-	data, err := f.Open("Dockerfile.tar.gz")
+	return strings.Contains(info.OperatingSystem, "Docker Desktop"), nil
+}
 
+// reports whether the daemon runs containers with userns-remap, in which case
+// root in the container is an unprivileged user on the host
+func (di *DockerImage) IsUsernsRemapped(ctxroot context.Context) (bool, error) {
+	info, err := di.Client.Info(ctxroot)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	for _, opt := range info.SecurityOptions {
+		if strings.Contains(opt, "name=userns") {
+			return true, nil
+		}
 	}
+	return false, nil
+}
 
-	img := DockerImage{
-		Client: client,
-		ContextRoot: ctxroot,
-		JSON: &DockerJSONWriter{},
-		URL: url,
-		SourceDir: sourcedir,
-	 }
+// stops timer, if any, as soon as the first read returns, data or not
+type stopOnRead struct {
+	r     io.Reader
+	timer *time.Timer
+	once  sync.Once
+}
+
+func (s *stopOnRead) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if s.timer != nil {
+		s.once.Do(func() { s.timer.Stop() })
+	}
+	return n, err
+}
+
+// copies the dump out of the named volume into SourceDir
+func (di *DockerImage) copyOut(ctxroot context.Context, id string) error {
+	rc, _, err := di.Client.CopyFromContainer(ctxroot, id, di.ContainerPath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return extractTar(rc, di.SourceDir, path.Base(di.ContainerPath))
+}
+
+// runs cmd in a throwaway, network-less container from the built image and returns its stdout
+func (di *DockerImage) RunCommand(ctxroot context.Context, cmd []string, mounts []mount.Mount) (string, error) {
+	return di.runCommand(ctxroot, cmd, &container.HostConfig{
+		NetworkMode: "none",
+		Mounts:      mounts,
+	})
+}
 
-	resp, err := client.ImageBuild(ctxroot, data, types.ImageBuildOptions{SuppressOutput: false})
+func (di *DockerImage) runCommand(ctxroot context.Context, cmd []string, hostConfig *container.HostConfig) (string, error) {
+	// helpers write into the same directory as the dump, so they run as the same user
+	hostConfig.UsernsMode = container.UsernsMode(di.UsernsMode)
+	body, err := di.Client.ContainerCreate(
+		ctxroot,
+		&container.Config{
+			Image:      di.ID,
+			Entrypoint: cmd,
+			Labels:     di.containerLabels(),
+		},
+		hostConfig,
+		&network.NetworkingConfig{},
+		&v1.Platform{
+			OS: "linux",
+		},
+		uuid.Generate().String(),
+	)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	defer di.Client.ContainerRemove(ctxroot, body.ID, types.ContainerRemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	})
+
+	chStatus, chErr := di.Client.ContainerWait(ctxroot, body.ID, container.WaitConditionNextExit)
+	if err := di.Client.ContainerStart(ctxroot, body.ID, types.ContainerStartOptions{}); err != nil {
+		return "", err
+	}
+	var status container.ContainerWaitOKBody
+	select {
+	case err := <-chErr:
+		return "", err
+	case status = <-chStatus:
 	}
-	err = img.JSON.Print("BUILD", resp.Body)
-	img.ID = strings.Split(img.JSON.Aux.ID, ":")[1]
+
+	rc, err := di.Client.ContainerLogs(ctxroot, body.ID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	})
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return &img, nil
+	defer rc.Close()
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, rc); err != nil {
+		return "", err
+	}
+	if status.StatusCode != 0 {
+		return "", fmt.Errorf("%s exited with status %d: %s", cmd[0], status.StatusCode, strings.TrimSpace(stderr.String()))
+	}
+	if stderr.Len() > 0 {
+		os.Stderr.Write(stderr.Bytes())
+	}
+	return stdout.String(), nil
 }
 
-func ConfigureFlags(url *string, output *string){
-	if *url == "" {
-		log.Fatal(errors.New("output directory must be specified"))
+// rootless docker listens under $XDG_RUNTIME_DIR instead of /var/run, which
+// client.FromEnv never looks at. Returns "" when the environment or the
+// default socket should be used.
+func rootlessDockerHost() string {
+	if os.Getenv("DOCKER_HOST") != "" {
+		return ""
+	}
+	if _, err := os.Stat("/var/run/docker.sock"); err == nil {
+		return ""
 	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	sock := filepath.Join(runtimeDir, "docker.sock")
+	if _, err := os.Stat(sock); err != nil {
+		return ""
+	}
+	return "unix://" + sock
+}
 
-	if *output == "" {
-		log.Fatal(errors.New("output directory must be specified"))
+// settings that only matter while building the image
+type BuildOptions struct {
+	// daemon to use instead of the environment's, unix://, tcp:// or ssh://
+	Host string
+	// registry credentials for pulling the base image
+	AuthConfigs map[string]types.AuthConfig
+	// receives a line per docker api call, may be nil
+	Trace io.Writer
+	// one of the CachePolicy constants, "" is CachePolicyContent
+	CachePolicy string
+	// NAME=value proxy variables passed to the build as build args
+	ProxyEnv []string
+	// a Dockerfile on disk to build instead of the embedded one
+	Dockerfile string
+	// directory sent as the build context with Dockerfile, "" is its directory
+	Context string
+	// leave the containers of intermediate build steps behind, even those of
+	// a failed step, instead of removing them
+	KeepIntermediate bool
+	// holds Dockerfile.tar.gz when Dockerfile is unset, nil is the one
+	// embedded at build time. Lets a build run against a stand-in context.
+	ContextFS fs.FS
+}
+
+const (
+	// reuse layers as long as the build context hasn't changed
+	CachePolicyContent = "content"
+	// also pull the base image, so a new digest upstream invalidates the cache
+	CachePolicyDigest = "digest"
+	// rebuild every layer
+	CachePolicyNone = "none"
+)
+
+// proxy variables are predefined build args, RUN steps see them without the
+// Dockerfile declaring them
+func buildArgs(env []string) map[string]*string {
+	args := map[string]*string{}
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		args[name] = &value
 	}
+	return args
+}
 
-	if strings.Contains(*output, "~") {
-		if homeDir, err := os.UserHomeDir(); err != nil {
-			log.Fatal(err)
-		} else {
-			*output = strings.Replace(*output, "~", homeDir, 1)
+// a client for host, or when that's empty the daemon the environment points
+// at or the rootless one. Every api call is written to trace unless it's nil.
+func newClient(host string, runID string, trace io.Writer) (*client.Client, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	var clientOpts []client.Opt
+	if strings.HasPrefix(host, "ssh://") {
+		// client.FromEnv would choke on an ssh DOCKER_HOST, and tls settings
+		// don't apply through ssh anyway
+		dial, err := sshDialer(host)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts = append(clientOpts, client.WithHost(sshDaemonHost), client.WithDialContext(dial))
+		if version := os.Getenv("DOCKER_API_VERSION"); version != "" {
+			clientOpts = append(clientOpts, client.WithVersion(version))
+		}
+	} else {
+		clientOpts = append(clientOpts, client.FromEnv)
+		if host != "" {
+			clientOpts = append(clientOpts, client.WithHost(host))
+		} else if rootless := rootlessDockerHost(); rootless != "" {
+			clientOpts = append(clientOpts, client.WithHost(rootless))
 		}
 	}
-	if !path.IsAbs(*output) {
-		if absp, err := filepath.Abs(*output); err != nil {
-			log.Fatal(err)
-		} else {
-			fmt.Println(absp)
-			*output = absp
+	if trace != nil {
+		clientOpts = append(clientOpts, withTrace(runID, trace))
+	}
+	return client.NewClientWithOpts(clientOpts...)
+}
+
+// the build context and the Dockerfile's path inside it, either the embedded
+// one or opts.Dockerfile with its context directory
+func buildContext(opts BuildOptions) (io.ReadCloser, string, error) {
+	if opts.Dockerfile == "" {
+		var fsys fs.FS = f
+		if opts.ContextFS != nil {
+			fsys = opts.ContextFS
+		}
+		if err := validateBuildContext(fsys, "Dockerfile.tar.gz"); err != nil {
+			return nil, "", err
+		}
+		data, err := fsys.Open("Dockerfile.tar.gz")
+		if err != nil {
+			return nil, "", err
 		}
+		return data, "Dockerfile", nil
+	}
+	dir := opts.Context
+	if dir == "" {
+		dir = filepath.Dir(opts.Dockerfile)
 	}
-	err := os.MkdirAll(*output, os.ModePerm)
+	rel, err := filepath.Rel(dir, opts.Dockerfile)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, "", fmt.Errorf("dockerfile %s is outside the build context %s", opts.Dockerfile, dir)
+	}
+	data, err := tarBuildContext(dir, filepath.ToSlash(rel))
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
 	}
-
+	return data, filepath.ToSlash(rel), nil
 }
 
-func main() {
-	var (
-		output string
-		url    string
-	)
-	flag.StringVar(&output, "o", "", "-o \"Some Output Directory\"")
-	flag.StringVar(&url, "u", "", "-u \"Some .git URL\"")
-	flag.Parse()
-	ConfigureFlags(&url, &output)
+// how often a build that failed on the network is attempted, and the delay
+// before the first retry, doubling after each
+const (
+	buildAttempts = 3
+	buildBackoff  = 2 * time.Second
+)
 
-	ctxroot := context.Background()
-	chID := make(chan string, 1)
-	img, err := NewDockerImage(ctxroot, url, output)
+// messages of failures pulling the base image that are worth another try.
+// Anything else, a failing RUN step or a broken Dockerfile, fails the same
+// way every time.
+var transientBuildErrors = []string{
+	"toomanyrequests",
+	"too many requests",
+	"i/o timeout",
+	"tls handshake timeout",
+	"connection reset by peer",
+	"unexpected eof",
+	"temporary failure in name resolution",
+	"500 internal server error",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+}
+
+func retryableBuildError(err error) bool {
+	if errors.Is(err, ErrDockerUnreachable) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range transientBuildErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
 
+// builds from the embedded dockerfile, or opts.Dockerfile when it's set,
+// retrying failures that look like a flaky registry
+func NewDockerImage(ctxroot context.Context, runID string, url string, sourcedir string, opts BuildOptions) (*DockerImage, error) {
+	cli, err := newClient(opts.Host, runID, opts.Trace)
 	if err != nil {
-		log.Fatal(err)
+		return nil, wrapKind(ErrDockerUnreachable, err)
+	}
+
+	img := DockerImage{
+		Client:        cli,
+		ContextRoot:   ctxroot,
+		RunID:         runID,
+		URL:           url,
+		SourceDir:     sourcedir,
+		ContainerPath: "/git",
+		EntrypointBin: "git-dumper",
+		Follow:        true,
+		VolumeMode:    VolumeModeBind,
 	}
 
-	err = img.CreateContainer(ctxroot, chID)
+	buildStarted := time.Now()
+	for attempt := 1; ; attempt++ {
+		err = img.build(opts)
+		if err == nil {
+			break
+		}
+		if attempt == buildAttempts || !retryableBuildError(err) {
+			return nil, err
+		}
+		delay := buildBackoff << (attempt - 1)
+		logWarning(runID, "BUILD", fmt.Sprintf("attempt %d of %d failed, retrying in %s: %v", attempt, buildAttempts, delay, err))
+		select {
+		case <-ctxroot.Done():
+			return nil, wrapKind(ErrBuildFailed, ctxroot.Err())
+		case <-time.After(delay):
+		}
+	}
+	img.BuildDuration = time.Since(buildStarted)
+	// FROM is never reported as cached, every step after it has to be
+	img.Reused = img.JSON.Steps > 0 && img.JSON.CachedSteps >= img.JSON.Steps-1
 
+	// a stream that looked fine can still leave no usable image behind, better
+	// to find out here than from a container that dies on start
+	inspect, _, err := cli.ImageInspectWithRaw(ctxroot, img.ID)
 	if err != nil {
-		log.Fatal(err)
+		return nil, wrapKind(ErrBuildFailed, fmt.Errorf("inspecting built image %s: %w", img.ID, err))
+	}
+	if inspect.Os != "linux" {
+		return nil, wrapKind(ErrBuildFailed, fmt.Errorf("built image %s is for %s, expected linux", img.ID, inspect.Os))
 	}
-	id := <-chID
-	err = img.RunContainer(ctxroot, id)
+	return &img, nil
+}
 
+// runs one ImageBuild and sets ID from its stream
+func (d *DockerImage) build(opts BuildOptions) error {
+	data, dockerfile, err := buildContext(opts)
 	if err != nil {
-		log.Fatal(err)
+		return wrapKind(ErrBuildFailed, err)
+	}
+	defer data.Close()
+
+	d.JSON = &DockerJSONWriter{RunID: d.RunID}
+	resp, err := d.Client.ImageBuild(d.ContextRoot, data, types.ImageBuildOptions{
+		SuppressOutput: false,
+		Remove:         !opts.KeepIntermediate,
+		ForceRemove:    !opts.KeepIntermediate,
+		Dockerfile:     dockerfile,
+		AuthConfigs:    opts.AuthConfigs,
+		PullParent:     opts.CachePolicy == CachePolicyDigest,
+		NoCache:        opts.CachePolicy == CachePolicyNone,
+		BuildArgs:      buildArgs(opts.ProxyEnv),
+		Labels: map[string]string{
+			labelImage:   "true",
+			labelVersion: ggetVersion(),
+		},
+	})
+	if client.IsErrConnectionFailed(err) {
+		return wrapKind(ErrDockerUnreachable, err)
+	}
+	if err != nil {
+		return wrapKind(ErrBuildFailed, err)
+	}
+	defer resp.Body.Close()
+	err = d.JSON.Emit("BUILD", resp.Body)
+	if err != nil {
+		return wrapKind(ErrBuildFailed, err)
+	}
+	if msg := d.JSON.ErrorDetail.Message; msg != "" {
+		return wrapKind(ErrBuildFailed, errors.New(msg))
+	}
+	if d.JSON.Aux.ID == "" {
+		return wrapKind(ErrBuildFailed, errors.New("no image id was reported"))
+	}
+	d.ID = strings.TrimPrefix(d.JSON.Aux.ID, "sha256:")
+	return nil
+}
+
+// reports whether the output directory had to be created
+func ConfigureFlags(url *string, output *string, mode os.FileMode, unsafeOutput bool) bool {
+	if *url == "" {
+		log.Fatal(wrapKind(ErrValidation, errors.New("url must be specified")))
+	}
+
+	if *output == "" {
+		log.Fatal(wrapKind(ErrValidation, errors.New("output directory must be specified")))
+	}
+
+	if strings.Contains(*output, "~") {
+		if homeDir, err := os.UserHomeDir(); err != nil {
+			log.Fatal(err)
+		} else {
+			*output = strings.Replace(*output, "~", homeDir, 1)
+		}
+	}
+	if !path.IsAbs(*output) {
+		if absp, err := filepath.Abs(*output); err != nil {
+			log.Fatal(err)
+		} else {
+			*output = absp
+		}
+	}
+	if err := checkOutputDir(*output, unsafeOutput); err != nil {
+		log.Fatal(wrapKind(ErrValidation, err))
+	}
+	_, err := os.Stat(*output)
+	created := os.IsNotExist(err)
+	err = os.MkdirAll(*output, mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return created
+}
+
+// the output directory is bind-mounted into a container running as root, so
+// pointing it at / or $HOME would let the dump write across the host
+func checkOutputDir(output string, unsafeOutput bool) error {
+	resolved := filepath.Clean(output)
+	if target, err := filepath.EvalSymlinks(resolved); err == nil {
+		resolved = target
+	}
+	if info, err := os.Stat(resolved); err == nil && !info.IsDir() {
+		return fmt.Errorf("output %s is a file, not a directory", output)
+	}
+	if unsafeOutput {
+		return nil
+	}
+	if resolved == string(filepath.Separator) {
+		return fmt.Errorf("refusing to dump into the root directory, pass -i-know-what-im-doing to override")
+	}
+	if homeDir, err := os.UserHomeDir(); err == nil && resolved == filepath.Clean(homeDir) {
+		return fmt.Errorf("refusing to dump into your home directory %s, pass -i-know-what-im-doing to override", homeDir)
+	}
+	return nil
+}
+
+// whether the daemon at host runs on another machine, where a bind mount
+// would refer to that machine's filesystem. tcp:// to a loopback address is
+// still this one.
+func isRemoteDaemon(host string) bool {
+	if strings.HasPrefix(host, "ssh://") {
+		return true
+	}
+	if !strings.HasPrefix(host, "tcp://") {
+		return false
+	}
+	u, err := neturl.Parse(host)
+	if err != nil {
+		return true
+	}
+	if u.Hostname() == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip == nil || !ip.IsLoopback()
+}
+
+// explains a dump that exited cleanly but left output empty. With a bind
+// mount that's nearly always the daemon writing to a filesystem other than
+// this one, which nothing else reports.
+func emptyOutputHint(volumeMode string, daemonHost string) string {
+	if volumeMode == VolumeModeNamed {
+		return "the dump volume was empty too, so git-dumper found nothing to download: check that the url points at an exposed .git directory"
+	}
+	hint := "with -volume-mode bind the daemon writes into its own view of the output path, so it most likely doesn't see this directory."
+	if isRemoteDaemon(daemonHost) {
+		hint += " The daemon at " + daemonHost + " is remote, the files are on that host."
+	} else {
+		hint += " Common causes are a DOCKER_HOST pointing at another machine, Docker Desktop without this directory in its file sharing, or a daemon in another VM or WSL distribution."
+	}
+	return hint + " Rerun with -volume-mode named to copy the dump out of a volume instead."
+}
+
+// finds a repository the dumper nested one directory down (output/<name>/.git,
+// as some custom images do). Returns the nested directory's name, or "" when
+// the layout was already as expected.
+func nestedRepo(output string) (string, error) {
+	if _, err := os.Lstat(filepath.Join(output, ".git")); err == nil {
+		return "", nil
+	}
+	entries, err := os.ReadDir(output)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return "", err
+	}
+	name := entries[0].Name()
+	nested := filepath.Join(output, name)
+	if _, err := os.Lstat(filepath.Join(nested, ".git")); err != nil {
+		return "", nil
+	}
+	inner, err := os.ReadDir(nested)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range inner {
+		// moving up an entry named like the nested directory would collide with it
+		if e.Name() == name {
+			return "", fmt.Errorf("%s contains %s, not flattening it", nested, e.Name())
+		}
+	}
+	return name, nil
+}
+
+// moves the contents of output/name up into output. In bind mode git-dumper
+// wrote them as the container's root user, so the move runs in a helper
+// container, anywhere else they were copied out by gget and are the caller's.
+func (di *DockerImage) flattenNested(ctxroot context.Context, output string, name string) error {
+	if di.VolumeMode == VolumeModeBind {
+		return di.Flatten(ctxroot, name)
+	}
+	nested := filepath.Join(output, name)
+	inner, err := os.ReadDir(nested)
+	if err != nil {
+		return err
+	}
+	for _, e := range inner {
+		if err := os.Rename(filepath.Join(nested, e.Name()), filepath.Join(output, e.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(nested)
+}
+
+// removes what a failed dump left behind. Only directories gget created or
+// that were empty beforehand are touched, so existing files are never lost.
+func cleanOutput(output string, created bool, empty bool) error {
+	if created {
+		return os.RemoveAll(output)
+	}
+	if !empty {
+		return nil
+	}
+	entries, err := os.ReadDir(output)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(output, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// how long cleanDump's helper container may take, it can't use the run's
+// context since an aborted dump is cleaned up too
+const cleanTimeout = time.Minute
+
+// cleanOutput for a failed dump. In bind mode git-dumper wrote its files as
+// the container's root user, so what the host isn't allowed to remove is
+// removed from a helper container instead.
+func (di *DockerImage) cleanDump(output string, created bool, empty bool) error {
+	err := cleanOutput(output, created, empty)
+	if !errors.Is(err, fs.ErrPermission) || di.VolumeMode != VolumeModeBind {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cleanTimeout)
+	defer cancel()
+	if err := di.EmptyOutput(ctx); err != nil {
+		return fmt.Errorf("emptying %s from a container: %w", output, err)
+	}
+	return cleanOutput(output, created, empty)
+}
+
+// reports whether name was given on the command line rather than defaulted
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// pairs of flags that can't be given together, and why
+var flagConflicts = []struct{ a, b, why string }{
+	{"only-index", "mirror", "-only-index downloads no objects to mirror"},
+	{"only-index", "branch", "-only-index downloads no objects to check out"},
+	{"only-index", "commit-times", "-only-index downloads no history to take times from"},
+	{"only-index", "verify-packs", "-only-index downloads no packs to verify"},
+	{"only-index", "min-objects", "-only-index downloads no objects to count"},
+	{"only-index", "manifest", "-only-index stops before the manifest is written"},
+	{"only-index", "post-hook", "-only-index stops before the hook runs"},
+	{"only-index", "output-tree", "-only-index stops before the tree is printed"},
+	{"only-index", "output-owner", "-only-index stops before the owner is changed"},
+	{"only-index", "upload", "-only-index stops before the upload"},
+	{"only-index", "diff-against", "-only-index stops before the diff"},
+	{"objects-dir-only", "only-index", "-only-index downloads no objects to keep"},
+	{"dump-config-only", "only-index", "each fetches its own handful of files instead of dumping"},
+	{"dump-config-only", "objects-dir-only", "-dump-config-only downloads no objects to keep"},
+	{"dump-config-only", "mirror", "-dump-config-only downloads no objects to mirror"},
+	{"dump-config-only", "branch", "-dump-config-only downloads no objects to check out"},
+	{"dump-config-only", "commit-times", "-dump-config-only downloads no history to take times from"},
+	{"dump-config-only", "verify-packs", "-dump-config-only downloads no packs to verify"},
+	{"dump-config-only", "min-objects", "-dump-config-only downloads no objects to count"},
+	{"dump-config-only", "manifest", "-dump-config-only stops before the manifest is written"},
+	{"dump-config-only", "post-hook", "-dump-config-only stops before the hook runs"},
+	{"dump-config-only", "output-tree", "-dump-config-only stops before the tree is printed"},
+	{"dump-config-only", "output-owner", "-dump-config-only stops before the owner is changed"},
+	{"dump-config-only", "upload", "-dump-config-only stops before the upload"},
+	{"dump-config-only", "diff-against", "-dump-config-only stops before the diff"},
+	{"plan", "only-index", "-plan downloads nothing, -only-index downloads the index"},
+	{"plan", "dump-config-only", "-plan downloads nothing, -dump-config-only downloads the config"},
+	{"plan", "objects-dir-only", "-plan downloads no objects to keep"},
+	{"plan", "mirror", "-plan downloads no objects to mirror"},
+	{"plan", "branch", "-plan downloads no objects to check out"},
+	{"plan", "commit-times", "-plan downloads no history to take times from"},
+	{"plan", "verify-packs", "-plan downloads no packs to verify"},
+	{"plan", "min-objects", "-plan downloads no objects to count"},
+	{"plan", "manifest", "-plan stops before the manifest is written"},
+	{"plan", "post-hook", "-plan stops before the hook runs"},
+	{"plan", "output-tree", "-plan stops before the tree is printed"},
+	{"plan", "output-owner", "-plan stops before the owner is changed"},
+	{"plan", "upload", "-plan stops before the upload"},
+	{"plan", "diff-against", "-plan stops before the diff"},
+	{"objects-dir-only", "branch", "-objects-dir-only drops the refs a branch is found by"},
+	{"objects-dir-only", "mirror", "-objects-dir-only drops the refs a mirror is pushed from"},
+	{"objects-dir-only", "commit-times", "-objects-dir-only drops the working tree and refs"},
+	{"no-entrypoint-override", "entrypoint-bin", "the image's own entrypoint runs instead of -entrypoint-bin"},
+	{"client-cert", "entrypoint-bin", "-client-cert runs git-dumper from a wrapper instead of -entrypoint-bin"},
+	{"client-cert", "no-entrypoint-override", "-client-cert runs git-dumper from a wrapper instead of the image's entrypoint"},
+	{"summary-only", "follow", "-summary-only hides the output -follow would stream"},
+	{"manifest-path", "paths", "each path's manifest goes into its own directory"},
+	{"diff-against", "paths", "each path would need a baseline of its own"},
+	{"jobs", "u", "the jobs file lists the urls"},
+	{"jobs", "paths", "the jobs file lists the targets"},
+	{"manifest-path", "jobs", "each job's manifest goes into its own directory"},
+	{"diff-against", "jobs", "each job would need a baseline of its own"},
+	{"sweep", "u", "the sweep pattern generates the urls"},
+	{"sweep", "paths", "the sweep pattern generates the targets"},
+	{"sweep", "jobs", "the jobs file lists the targets"},
+	{"manifest-path", "sweep", "each host's manifest goes into its own directory"},
+	{"diff-against", "sweep", "each host would need a baseline of its own"},
+}
+
+// flags that do nothing without another one
+var flagRequires = []struct{ flag, needs string }{
+	{"require-min-objects", "min-objects"},
+	{"hook-required", "post-hook"},
+	{"hook-timeout", "post-hook"},
+	{"tree-depth", "output-tree"},
+	{"max-dumps", "paths|jobs|sweep"},
+	{"keep-going-after-build-failure", "paths|jobs|sweep"},
+	{"allow-sweep", "sweep"},
+	{"max-sweep", "sweep"},
+	{"probe-concurrency", "sweep"},
+	{"since-commit", "mirror"},
+	{"client-cert", "client-key"},
+	{"client-key", "client-cert"},
+	{"context", "dockerfile"},
+	{"no-entrypoint-override", "dockerfile"},
+	{"upload-key", "upload"},
+	{"diff-json", "diff-against"},
+	{"plan-json", "plan"},
+}
+
+// whether name was given on the command line and not switched off with =false
+func flagEnabled(name string) bool {
+	return flagSet(name) && flag.Lookup(name).Value.String() != "false"
+}
+
+// rejects flag combinations that would otherwise have one flag silently
+// ignored. Only flags given on the command line count, defaults never conflict.
+func checkFlagConflicts() error {
+	for _, c := range flagConflicts {
+		if flagEnabled(c.a) && flagEnabled(c.b) {
+			return fmt.Errorf("-%s and -%s can't be used together: %s", c.a, c.b, c.why)
+		}
+	}
+	for _, r := range flagRequires {
+		if !flagEnabled(r.flag) {
+			continue
+		}
+		// needs can list alternatives, any one of them will do
+		alternatives := strings.Split(r.needs, "|")
+		found := false
+		for _, needs := range alternatives {
+			found = found || flagEnabled(needs)
+		}
+		if !found {
+			return fmt.Errorf("-%s has no effect without -%s", r.flag, strings.Join(alternatives, " or -"))
+		}
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// expands a leading ~ and makes p absolute
+func expandPath(p string) (string, error) {
+	if strings.HasPrefix(p, "~") {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		p = strings.Replace(p, "~", homeDir, 1)
+	}
+	return filepath.Abs(p)
+}
+
+func main() {
+	var (
+		output        string
+		url           string
+		mirror        string
+		listRefs      bool
+		probeTimeout  time.Duration
+		branch        string
+		verify        bool
+		clean         bool
+		commitTimes   bool
+		outputTree    bool
+		treeDepth     int
+		verbose       bool
+		socks5        string
+		reportCSV     string
+		containerPath string
+		entrypointBin string
+		name          string
+		mode          string
+		registryAuth  string
+		follow        bool
+		unsafeOutput  bool
+		volumeMode    string
+		attachTimeout time.Duration
+		heartbeat     time.Duration
+		onlyIndex     bool
+		configOnly    bool
+		plan          bool
+		retryOnEmpty  int
+		saveLog       bool
+		planJSON      bool
+		trace         string
+		allowHosts    stringList
+		denyHosts     stringList
+		cachePolicy   string
+		dockerHost    string
+		minObjects    int
+		requireMin    bool
+		maxRedirects  int
+		paths         string
+		banner        bool
+		keepVolumes   bool
+		postHook      string
+		hookTimeout   time.Duration
+		hookRequired  bool
+		summaryOnly   bool
+		userns        string
+		writeManifest bool
+		manifestPath  string
+		maxDumps      int
+		keepGoing     bool
+		dockerfile    string
+		contextDir    string
+		outputOwner   string
+		upload        string
+		uploadKey     string
+		diffAgainst   string
+		diffJSON      bool
+		userLabels    stringList
+		addHosts      stringList
+		sinceCommit   string
+		clientCert    string
+		clientKey     string
+		dnsServers    stringList
+		objectsOnly   bool
+		jobsFile      string
+		killGrace     time.Duration
+		rmIntermed    bool
+		sweep         string
+		allowSweep    bool
+		maxSweep      int
+		keepEntry     bool
+		probeWorkers  int
+		printOutDir   bool
+		retries       int
+		timeout       int
+	)
+	flag.StringVar(&output, "o", "", "-o \"Some Output Directory\"")
+	flag.StringVar(&url, "u", "", "-u \"Some .git URL\"")
+	flag.StringVar(&mirror, "mirror", "", "-mirror \"Some Bare Repository Directory\" to create or update from the dump")
+	flag.StringVar(&sinceCommit, "since-commit", "", "-since-commit \"sha\" the mirror was last updated to, failing if the dump doesn't contain it and reporting how many commits are new")
+	flag.BoolVar(&listRefs, "list-refs", false, "-list-refs enumerate branches and tags before dumping")
+	flag.DurationVar(&probeTimeout, "probe-timeout", 5*time.Second, "-probe-timeout \"5s\" per request when probing the target over http")
+	flag.StringVar(&branch, "branch", "", "-branch \"Some Branch\" to check out instead of the default HEAD")
+	flag.BoolVar(&verify, "verify-packs", true, "-verify-packs check recovered pack files for truncation, disable with -verify-packs=false")
+	flag.BoolVar(&clean, "clean-on-failure", false, "-clean-on-failure remove partial output when the dump fails or is aborted")
+	flag.BoolVar(&commitTimes, "commit-times", false, "-commit-times set each file's mtime to its last commit time, slow on large histories")
+	flag.BoolVar(&outputTree, "output-tree", false, "-output-tree print the recovered directory as a tree after the dump")
+	flag.IntVar(&treeDepth, "tree-depth", 3, "-tree-depth \"3\" levels of -output-tree to expand")
+	flag.BoolVar(&verbose, "v", false, "-v print extra detail about what gget is doing")
+	flag.StringVar(&socks5, "socks5", "", "-socks5 \"host:port\" of a SOCKS5 proxy (e.g. Tor) to route the dump through")
+	flag.StringVar(&reportCSV, "report-csv", "", "-report-csv \"Some File\" to write a csv summary of the run to")
+	flag.StringVar(&containerPath, "container-path", "/git", "-container-path \"/git\" the output directory is mounted at inside the container")
+	flag.StringVar(&entrypointBin, "entrypoint-bin", "git-dumper", "-entrypoint-bin \"git-dumper\" binary the container runs with the url and target path")
+	flag.StringVar(&name, "name", "", "-name \"Some Container Name\" instead of a random one, suffixed with -1, -2, ... if taken")
+	flag.StringVar(&mode, "output-mode", "0755", "-output-mode \"0755\" octal permissions for directories gget creates")
+	flag.StringVar(&registryAuth, "registry-auth", defaultDockerConfig(), "-registry-auth \"Some Docker Config File\" with credentials and proxies for the build and dump, defaults to $DOCKER_CONFIG/config.json")
+	flag.BoolVar(&follow, "follow", isTerminal(os.Stdout), "-follow stream git-dumper output live, defaults to on when stdout is a terminal")
+	flag.BoolVar(&unsafeOutput, "i-know-what-im-doing", false, "-i-know-what-im-doing allow -o to be / or your home directory")
+	flag.StringVar(&volumeMode, "volume-mode", VolumeModeBind, "-volume-mode \"bind|named\" named dumps into a docker volume and copies it out, for remote daemons")
+	flag.DurationVar(&attachTimeout, "attach-timeout", 2*time.Minute, "-attach-timeout \"2m\" to wait for container output before giving up, 0 to wait forever")
+	flag.DurationVar(&heartbeat, "heartbeat", 30*time.Second, "-heartbeat \"30s\" of silence before logging that the dump is still running, 0 to disable")
+	flag.BoolVar(&saveLog, "save-log", false, "-save-log write each target's full log, uncolored, to gget.log in its output directory")
+	flag.IntVar(&retryOnEmpty, "retry-on-empty", 0, "-retry-on-empty \"2\" times to rerun a dump that recovered nothing from a target the probe found exposed")
+	flag.BoolVar(&plan, "plan", false, "-plan list the packs and loose ref objects a dump would fetch and their size, without downloading them")
+	flag.BoolVar(&planJSON, "plan-json", false, "-plan-json print the -plan result as json on stdout")
+	flag.BoolVar(&configOnly, "dump-config-only", false, "-dump-config-only fetch config, HEAD and packed-refs and list remotes, branches and credentials without dumping")
+	flag.BoolVar(&onlyIndex, "only-index", false, "-only-index fetch HEAD, packed-refs and the index and list tracked files without dumping any objects")
+	flag.StringVar(&trace, "trace", "", "-trace \"Some File\" to log every docker api call to, - for stderr")
+	flag.Var(&allowHosts, "allow-host", "-allow-host \"example.com\" only dump hosts matching this suffix or glob, repeatable")
+	flag.Var(&denyHosts, "deny-host", "-deny-host \"example.com\" never dump hosts matching this suffix or glob, repeatable, wins over -allow-host")
+	flag.StringVar(&cachePolicy, "cache-policy", CachePolicyContent, "-cache-policy \"content|digest|none\" digest pulls the base image so updates to it trigger a rebuild, none always rebuilds")
+	flag.StringVar(&dockerHost, "H", "", "-H \"ssh://user@bastion\" docker daemon to run on instead of $DOCKER_HOST, unix://, tcp:// or ssh://")
+	flag.IntVar(&minObjects, "min-objects", 0, "-min-objects \"10\" warn when fewer git objects than this were recovered")
+	flag.BoolVar(&requireMin, "require-min-objects", false, "-require-min-objects fail instead of warning when -min-objects isn't reached")
+	flag.IntVar(&maxRedirects, "max-redirects", 5, "-max-redirects \"5\" to follow when probing whether the target sits behind a login")
+	flag.IntVar(&retries, "request-retries", 3, "-request-retries \"3\" times git-dumper retries a failed request")
+	flag.IntVar(&timeout, "request-timeout", 3, "-request-timeout \"3\" seconds git-dumper waits for each request")
+	flag.StringVar(&paths, "paths", "", "-paths \"/app,/api\" to dump under -u, each into its own subdirectory of -o")
+	flag.BoolVar(&banner, "banner", isTerminal(os.Stdout), "-banner print versions and key settings at startup, defaults to on when stdout is a terminal")
+	flag.BoolVar(&keepVolumes, "keep-volumes", false, "-keep-volumes leave the dump's docker volumes behind for inspection, gget prune removes them later")
+	flag.StringVar(&postHook, "post-hook", "", "-post-hook \"Some Command\" to run through sh after each successful dump, with GGET_* variables describing it")
+	flag.DurationVar(&hookTimeout, "hook-timeout", 5*time.Minute, "-hook-timeout \"5m\" before the post hook is killed, 0 to wait forever")
+	flag.BoolVar(&hookRequired, "hook-required", false, "-hook-required fail the dump when the post hook fails instead of warning")
+	flag.BoolVar(&printOutDir, "print-output-dir", false, "-print-output-dir print each target's resolved output directory on its own line before dumping")
+	flag.BoolVar(&summaryOnly, "summary-only", false, "-summary-only hide build and git-dumper output and print a table of results at the end")
+	flag.StringVar(&userns, "userns", "", "-userns \"remap|host\" remap requires the daemon's userns-remap so files aren't owned by root, host opts out of it")
+	flag.BoolVar(&writeManifest, "manifest", false, "-manifest write every recovered file's path, size and sha256 to "+manifestName+" in the output directory")
+	flag.StringVar(&manifestPath, "manifest-path", "", "-manifest-path \"Some File\" to write the -manifest to instead")
+	flag.IntVar(&maxDumps, "max-dumps", 0, "-max-dumps \"10\" stop after this many -paths or -jobs targets, 0 for no limit")
+	flag.StringVar(&dockerfile, "dockerfile", "", "-dockerfile \"Some Dockerfile\" to build the image from instead of the embedded one")
+	flag.StringVar(&contextDir, "context", "", "-context \"Some Directory\" sent as the build context for -dockerfile, defaults to the Dockerfile's directory")
+	flag.StringVar(&outputOwner, "output-owner", "", "-output-owner \"1000:1000\" uid:gid to chown the output directory to after the dump, needs root")
+	flag.StringVar(&upload, "upload", "", "-upload \"sftp://user@host/path\" to copy each successful dump to as a .tar.gz, over sftp or with ssh:// through a shell")
+	flag.StringVar(&uploadKey, "upload-key", "", "-upload-key \"Some Key File\" for -upload instead of the ssh agent and defaults")
+	flag.StringVar(&diffAgainst, "diff-against", "", "-diff-against \"Some Manifest Or Directory\" of a previous dump to report added, removed and changed files against")
+	flag.BoolVar(&diffJSON, "diff-json", false, "-diff-json print the -diff-against result as json on stdout")
+	flag.StringVar(&clientCert, "client-cert", "", "-client-cert \"Some PEM Certificate\" to present to the target for mutual tls, needs -client-key")
+	flag.StringVar(&clientKey, "client-key", "", "-client-key \"Some PEM Key\" for -client-cert")
+	flag.Var(&addHosts, "add-host", "-add-host \"name:ip\" to add to the dump container's /etc/hosts, repeatable")
+	flag.Var(&dnsServers, "dns", "-dns \"ip\" of a dns server for the dump container to use, repeatable")
+	flag.Var(&userLabels, "container-label", "-container-label \"key=value\" to label every container gget creates with, repeatable")
+	flag.BoolVar(&objectsOnly, "objects-dir-only", false, "-objects-dir-only keep just .git/objects from the dump, dropping refs, index, config and the working tree")
+	flag.StringVar(&jobsFile, "jobs", "", "-jobs \"Some Job File\" json array, or .yaml/.yml list, of targets with per-target output, branch, threads and headers, each dumped under -o")
+	flag.DurationVar(&killGrace, "kill-grace", 5*time.Second, "-kill-grace \"5s\" a dump that's cut short gets to exit after SIGTERM before it's killed, 0 kills it straight away")
+	flag.BoolVar(&rmIntermed, "rm-intermediate", true, "-rm-intermediate remove the containers of intermediate build steps, -rm-intermediate=false keeps them for debugging a Dockerfile")
+	flag.StringVar(&sweep, "sweep", "", "-sweep \"https://10.0.0.0/24/.git\" url pattern with an ipv4 network or {a,b} and {1..9} groups, dumping every host that exposes .git")
+	flag.BoolVar(&allowSweep, "allow-sweep", false, "-allow-sweep confirm that -sweep may probe every host its pattern expands to")
+	flag.IntVar(&maxSweep, "max-sweep", 256, "-max-sweep \"256\" most urls a -sweep pattern may expand to")
+	flag.BoolVar(&keepEntry, "no-entrypoint-override", false, "-no-entrypoint-override keep the -dockerfile image's entrypoint and pass it the dumper's arguments as its command")
+	flag.IntVar(&probeWorkers, "probe-concurrency", 16, "-probe-concurrency \"16\" -sweep urls probed at once, dumps still run one at a time")
+	flag.BoolVar(&keepGoing, "keep-going-after-build-failure", false, "-keep-going-after-build-failure carry on with the remaining -paths or -jobs targets when the image fails to build for one")
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		if len(os.Args) != 3 {
+			log.Fatal(errors.New("usage: gget completion bash|zsh|fish"))
+		}
+		if err := WriteCompletion(os.Stdout, os.Args[2], flag.CommandLine); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "images" {
+		fs := flag.NewFlagSet("images", flag.ExitOnError)
+		asJSON := fs.Bool("json", false, "-json print the images as a json array")
+		host := fs.String("H", "", "-H \"ssh://user@bastion\" docker daemon to list instead of $DOCKER_HOST")
+		fs.Parse(os.Args[2:])
+		if err := ListImages(context.Background(), os.Stdout, *host, *asJSON); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+		host := fs.String("H", "", "-H \"ssh://user@bastion\" docker daemon to check instead of $DOCKER_HOST")
+		dockerfile := fs.String("dockerfile", "", "-dockerfile \"Some Dockerfile\" to build instead of the embedded one")
+		contextDir := fs.String("context", "", "-context \"Some Directory\" sent as the build context for -dockerfile")
+		entrypointBin := fs.String("entrypoint-bin", "git-dumper", "-entrypoint-bin \"git-dumper\" binary to run with --help in the image")
+		fs.Parse(os.Args[2:])
+		opts := BuildOptions{Host: *host}
+		var err error
+		if *dockerfile != "" {
+			if opts.Dockerfile, err = expandPath(*dockerfile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *contextDir != "" {
+			if opts.Context, err = expandPath(*contextDir); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if opts.AuthConfigs, err = LoadRegistryAuth(defaultDockerConfig()); err != nil {
+			log.Fatal(err)
+		}
+		proxyHost := *host
+		if proxyHost == "" {
+			proxyHost = os.Getenv("DOCKER_HOST")
+		}
+		if opts.ProxyEnv, err = LoadProxyEnv(defaultDockerConfig(), proxyHost); err != nil {
+			log.Fatal(err)
+		}
+		// the build output is noise here, failures are reported either way
+		logger = &summaryLogger{Next: logger}
+		if err := Doctor(context.Background(), os.Stdout, *entrypointBin, opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "prune" {
+		fs := flag.NewFlagSet("prune", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "-dry-run list what would be removed without removing it")
+		host := fs.String("H", "", "-H \"ssh://user@bastion\" docker daemon to prune instead of $DOCKER_HOST")
+		force := fs.Bool("force", false, "-force also remove the containers, volumes and images of runs still in progress")
+		fs.Parse(os.Args[2:])
+		if err := Prune(context.Background(), *host, *dryRun, *force); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	flag.Parse()
+	if err := checkFlagConflicts(); err != nil {
+		log.Fatal(wrapKind(ErrValidation, err))
+	}
+	if err := validateDumperArgs(flag.Args()); err != nil {
+		log.Fatal(wrapKind(ErrValidation, err))
+	}
+	for _, arg := range flag.Args() {
+		if socks5 != "" && (arg == "--proxy" || strings.HasPrefix(arg, "--proxy=")) {
+			log.Fatal(wrapKind(ErrValidation, errors.New("-socks5 and a --proxy passed to git-dumper can't be used together: both set git-dumper's proxy")))
+		}
+	}
+	if socks5 != "" {
+		if _, _, err := net.SplitHostPort(socks5); err != nil {
+			log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-socks5 must be host:port: %w", err)))
+		}
+	}
+	if strings.TrimSpace(entrypointBin) == "" {
+		log.Fatal(wrapKind(ErrValidation, errors.New("-entrypoint-bin must not be empty")))
+	}
+	if volumeMode != VolumeModeBind && volumeMode != VolumeModeNamed {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-volume-mode must be bind or named, got %q", volumeMode)))
+	}
+	switch cachePolicy {
+	case CachePolicyContent, CachePolicyDigest, CachePolicyNone:
+	default:
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-cache-policy must be content, digest or none, got %q", cachePolicy)))
+	}
+	if userns != "" && userns != "remap" && userns != "host" {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-userns must be remap or host, got %q", userns)))
+	}
+	if retries < 1 {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-request-retries must be at least 1, got %d", retries)))
+	}
+	if timeout < 1 {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-request-timeout must be at least 1 second, got %d", timeout)))
+	}
+	if killGrace < 0 {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-kill-grace must not be negative, got %s", killGrace)))
+	}
+	if maxRedirects < 0 {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-max-redirects must not be negative, got %d", maxRedirects)))
+	}
+	if maxDumps < 0 {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-max-dumps must not be negative, got %d", maxDumps)))
+	}
+	if minObjects < 0 {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-min-objects must not be negative, got %d", minObjects)))
+	}
+	if !path.IsAbs(containerPath) {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-container-path must be absolute, got %q", containerPath)))
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-output-mode must be octal permissions like 0755, got %q", mode)))
+	}
+	outputMode := os.FileMode(perm)
+	ownerUID, ownerGID := -1, -1
+	if outputOwner != "" {
+		if runtime.GOOS == "windows" {
+			log.Printf("-output-owner has no effect on windows, ignoring it")
+		} else if ownerUID, ownerGID, err = parseOwner(outputOwner); err != nil {
+			log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-output-owner: %w", err)))
+		}
+	}
+	if mirror != "" {
+		absp, err := expandPath(mirror)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mirror = absp
+		if err := os.MkdirAll(mirror, outputMode); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var clientPair *tls.Certificate
+	if clientCert != "" {
+		for _, p := range []*string{&clientCert, &clientKey} {
+			absp, err := expandPath(*p)
+			if err != nil {
+				log.Fatal(err)
+			}
+			*p = absp
+		}
+		pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-client-cert: %w", err)))
+		}
+		clientPair = &pair
+	}
+	if retryOnEmpty < 0 {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-retry-on-empty must not be negative, got %d", retryOnEmpty)))
+	}
+	if sinceCommit != "" && !commitPattern.MatchString(sinceCommit) {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-since-commit %q is not a commit sha", sinceCommit)))
+	}
+
+	if manifestPath != "" {
+		absp, err := expandPath(manifestPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		manifestPath = absp
+		writeManifest = true
+	}
+
+	if dockerfile != "" {
+		absp, err := expandPath(dockerfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dockerfile = absp
+		if info, err := os.Stat(dockerfile); err != nil || !info.Mode().IsRegular() {
+			log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-dockerfile %s is not a file", dockerfile)))
+		}
+	}
+	if contextDir != "" {
+		absp, err := expandPath(contextDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		contextDir = absp
+		if info, err := os.Stat(contextDir); err != nil || !info.IsDir() {
+			log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-context %s is not a directory", contextDir)))
+		}
+		if rel, err := filepath.Rel(contextDir, dockerfile); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-dockerfile %s must be inside -context %s", dockerfile, contextDir)))
+		}
+	}
+
+	if diffAgainst != "" {
+		absp, err := expandPath(diffAgainst)
+		if err != nil {
+			log.Fatal(err)
+		}
+		diffAgainst = absp
+	}
+
+	containerLabels, err := parseLabels(userLabels)
+	if err != nil {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-container-label: %w", err)))
+	}
+	extraHosts, err := parseExtraHosts(addHosts)
+	if err != nil {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-add-host: %w", err)))
+	}
+	dns, err := parseDNS(dnsServers)
+	if err != nil {
+		log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-dns: %w", err)))
+	}
+
+	var uploadTo *uploadTarget
+	if upload != "" {
+		if uploadTo, err = parseUploadTarget(upload); err != nil {
+			log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-upload: %w", err)))
+		}
+		if uploadKey != "" {
+			if uploadTo.KeyFile, err = expandPath(uploadKey); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if reportCSV != "" {
+		absp, err := expandPath(reportCSV)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reportCSV = absp
+	}
+
+	// a tarpit host must not hang probing the way it could hang the dump
+	var probeTransport http.RoundTripper
+	if socks5 != "" || clientPair != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		if socks5 != "" {
+			// probes must not give away more than the dump does
+			t.Proxy = http.ProxyURL(&neturl.URL{Scheme: "socks5", Host: socks5})
+		}
+		if clientPair != nil {
+			t.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{*clientPair}}
+		}
+		probeTransport = t
+	}
+	hc := &http.Client{Transport: newRateLimitTransport(probeTransport, probeTimeout)}
+
+	var targets []target
+	switch {
+	case sweep != "":
+		if !allowSweep {
+			log.Fatal(wrapKind(ErrValidation, errors.New("-sweep probes every host its pattern expands to, pass -allow-sweep to confirm")))
+		}
+		if output == "" {
+			log.Fatal(wrapKind(ErrValidation, errors.New("output directory must be specified")))
+		}
+		if probeWorkers < 1 {
+			log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-probe-concurrency must be at least 1, got %d", probeWorkers)))
+		}
+		if maxSweep < 1 {
+			log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-max-sweep must be at least 1, got %d", maxSweep)))
+		}
+		urls, err := expandSweep(sweep, maxSweep)
+		if err != nil {
+			log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-sweep: %w", err)))
+		}
+		// a sweep must not even probe what's out of scope
+		var inScope []string
+		for _, u := range urls {
+			reason, err := checkScope(u, allowHosts, denyHosts)
+			if err != nil {
+				log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-sweep: %w", err)))
+			}
+			if reason == "" {
+				inScope = append(inScope, u)
+			}
+		}
+		if len(inScope) < len(urls) {
+			log.Printf("-sweep: leaving out %d of %d urls that are out of scope", len(urls)-len(inScope), len(urls))
+		}
+		if _, err := urlTargets(inScope, output, mirror); err != nil {
+			log.Fatal(wrapKind(ErrValidation, fmt.Errorf("-sweep: %w", err)))
+		}
+		exposed := exposedTargets(context.Background(), hc, inScope, probeWorkers)
+		log.Printf("-sweep: %d of %d urls expose .git", len(exposed), len(inScope))
+		targets, _ = urlTargets(exposed, output, mirror)
+	case jobsFile != "":
+		if output == "" {
+			log.Fatal(wrapKind(ErrValidation, errors.New("output directory must be specified")))
+		}
+		targets, err = jobTargets(jobsFile, output, mirror)
+		if err != nil {
+			log.Fatal(wrapKind(ErrValidation, err))
+		}
+		for _, t := range targets {
+			if t.Branch != "" && (onlyIndex || objectsOnly || configOnly || plan) {
+				log.Fatal(wrapKind(ErrValidation, fmt.Errorf("job %s sets a branch, which -only-index, -dump-config-only, -plan and -objects-dir-only can't check out", t.Name)))
+			}
+		}
+	default:
+		created := ConfigureFlags(&url, &output, outputMode, unsafeOutput)
+		targets = []target{{URL: url, Output: output, Mirror: mirror, Created: created}}
+	}
+	if paths != "" {
+		targets, err = pathTargets(url, output, mirror, strings.Split(paths, ","))
+		if err != nil {
+			log.Fatal(wrapKind(ErrValidation, err))
+		}
+	}
+	if paths != "" || jobsFile != "" || sweep != "" {
+		if maxDumps > 0 && len(targets) > maxDumps {
+			log.Printf("-max-dumps %d: dumping the first %d of %d targets, skipping the remaining %d", maxDumps, maxDumps, len(targets), len(targets)-maxDumps)
+			targets = targets[:maxDumps]
+		}
+		for i := range targets {
+			targets[i].Created = ConfigureFlags(&targets[i].URL, &targets[i].Output, outputMode, unsafeOutput)
+			if targets[i].Mirror != "" {
+				if err := os.MkdirAll(targets[i].Mirror, outputMode); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+	}
+	for i := range targets {
+		entries, _ := os.ReadDir(targets[i].Output)
+		targets[i].Empty = len(entries) == 0
+	}
+	// printed before anything else goes to stdout so scripts can take the
+	// first lines as they are
+	if printOutDir {
+		for _, t := range targets {
+			fmt.Println(t.Output)
+		}
+	}
+
+	if summaryOnly {
+		logger = &summaryLogger{Next: logger}
+	}
+
+	ctxroot, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if banner {
+		PrintBanner(ctxroot, os.Stdout, dockerHost, []string{
+			"volume-mode=" + volumeMode,
+			"cache-policy=" + cachePolicy,
+			"entrypoint=" + entrypointBin,
+			"container-path=" + containerPath,
+			fmt.Sprintf("socks5=%t", socks5 != ""),
+			fmt.Sprintf("follow=%t", follow),
+			fmt.Sprintf("targets=%d", len(targets)),
+		})
+	}
+
+	registryAuth, err = expandPath(registryAuth)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auths, err := LoadRegistryAuth(registryAuth)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the docker cli keys proxies by the host it talks to
+	proxyHost := dockerHost
+	if proxyHost == "" {
+		proxyHost = os.Getenv("DOCKER_HOST")
+	}
+	proxyEnv, err := LoadProxyEnv(registryAuth, proxyHost)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var traceOut io.Writer
+	switch trace {
+	case "":
+	case "-":
+		traceOut = os.Stderr
+	default:
+		trace, err = expandPath(trace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		traceFile, err := os.Create(trace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer traceFile.Close()
+		traceOut = traceFile
+	}
+
+	dump := func(t target) Result {
+		url, output, mirror := t.URL, t.Output, t.Mirror
+		created, empty := t.Created, t.Empty
+		branch, verify := branch, verify
+		if t.Branch != "" {
+			branch = t.Branch
+		}
+		runID := NewRunID()
+		var runLogger *runLog
+		if saveLog {
+			runLogger = &runLog{Next: logger, RunID: runID}
+			logger = runLogger
+			defer func() { logger = runLogger.Next }()
+		}
+		if t.Name != "" {
+			logInfo(runID, "RUN", "job", t.Name)
+		}
+		logInfo(runID, "RUN", "url", url)
+
+		started := time.Now()
+		result := Result{RunID: runID, Job: t.Name, URL: url, OutputDir: output}
+		var img *DockerImage
+		finish := func(err error) Result {
+			exitCode := 0
+			if img != nil {
+				exitCode = img.ExitCode
+				result.ImageID = img.ID
+				result.ImageReused = img.Reused
+				result.BuildDuration = img.BuildDuration
+			}
+			result.Finish(started, exitCode, err)
+			if runLogger != nil {
+				if err := runLogger.Save(output, err); err != nil {
+					log.Printf("[%s] saving the log: %v", runID, err)
+				}
+			}
+			return result
+		}
+		fail := func(err error) Result {
+			log.Printf("[%s] %v", runID, err)
+			return finish(err)
+		}
+
+		reason, err := checkScope(url, allowHosts, denyHosts)
+		if err != nil {
+			return fail(wrapKind(ErrValidation, err))
+		}
+		if reason != "" {
+			logWarning(runID, "SCOPE", "skipping "+url+": "+reason)
+			// nothing was dumped, don't leave the directory behind either
+			if err := cleanOutput(output, created, empty); err != nil {
+				log.Println(err)
+			}
+			return fail(wrapKind(ErrOutOfScope, errors.New(reason)))
+		}
+
+		// lets the probe's rate limiting say which run it held up
+		pctx := context.WithValue(ctxroot, runIDKey{}, runID)
+		// the credentials given to git-dumper may be what gets past a login
+		header := dumperHeaders(append(append([]string{}, flag.Args()...), t.DumperArgs...))
+		gated, probed, err := ProbeGated(pctx, hc, url, maxRedirects, header)
+		// .git/HEAD was served and isn't a login page, so an empty dump is
+		// more likely a transient block than nothing being there
+		looksExposed := err == nil && probed.Status == http.StatusOK
+		if err != nil {
+			logWarning(runID, "PROBE", err.Error())
+		} else {
+			result.ProbeStatus = probed.Status
+			result.Server = probed.Server
+			if verbose {
+				server := probed.Server
+				if server == "" {
+					server = "not given"
+				}
+				logInfo(runID, "PROBE", "head", fmt.Sprintf("status %d, server %s", probed.Status, server))
+			}
+		}
+		if gated != "" {
+			hint := ", pass credentials with -- -H \"Authorization=...\" if you have them"
+			if len(header) > 0 {
+				hint = ", even with the headers given to git-dumper"
+			}
+			logWarning(runID, "PROBE", "skipping "+url+": "+gated+hint)
+			if err := cleanOutput(output, created, empty); err != nil {
+				log.Println(err)
+			}
+			return fail(wrapKind(ErrTargetGated, errors.New(gated)))
+		}
+
+		if listing, err := ProbeListing(pctx, hc, url); err == nil && listing {
+			result.Listing = true
+			logInfo(runID, "PROBE", "listing", "directory listing is enabled, git-dumper will fetch .git recursively")
+		}
+
+		if listRefs {
+			refs, err := ListRefs(pctx, hc, url)
+			if err != nil {
+				logWarning(runID, "REFS", err.Error())
+			}
+			for _, ref := range refs {
+				logInfo(runID, "REFS", "ref", ref)
+			}
+			result.Refs = refs
+		}
+
+		if plan {
+			p, err := PlanFetch(pctx, hc, url)
+			if cerr := cleanOutput(output, created, empty); cerr != nil {
+				log.Println(cerr)
+			}
+			if err != nil {
+				return fail(wrapKind(ErrNothingRecovered, fmt.Errorf("planning: %w", err)))
+			}
+			if planJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(p); err != nil {
+					return fail(err)
+				}
+			} else {
+				for _, f := range append(append([]planFile{}, p.Packs...), p.Loose...) {
+					size := "size unknown"
+					if f.Size >= 0 {
+						size = humanSize(f.Size)
+					}
+					logInfo(runID, "PLAN", "file", fmt.Sprintf("%s %s", f.Path, size))
+				}
+			}
+			summary := fmt.Sprintf("refs: %d, pack and index files: %d, loose objects at ref tips: %d, total %s", len(p.Refs), len(p.Packs), len(p.Loose), humanSize(p.Size))
+			if p.Unknown > 0 {
+				summary += fmt.Sprintf(" plus %d files of unknown size", p.Unknown)
+			}
+			logInfo(runID, "PLAN", "summary", summary+"; older loose objects are only found while dumping")
+			result.Partial = true
+			return finish(nil)
+		}
+
+		if configOnly {
+			info, err := FetchConfig(pctx, hc, url, output, outputMode)
+			if err != nil {
+				return fail(wrapKind(ErrNothingRecovered, fmt.Errorf("fetching config: %w", err)))
+			}
+			if info.Head != "" {
+				logInfo(runID, "CONFIG", "head", info.Head)
+			}
+			for _, r := range info.Remotes {
+				logInfo(runID, "CONFIG", "remote", r)
+			}
+			for _, b := range info.Branches {
+				logInfo(runID, "CONFIG", "branch", b)
+			}
+			for _, ref := range info.Refs {
+				logInfo(runID, "CONFIG", "ref", ref)
+			}
+			for _, c := range info.Credentials {
+				logWarning(runID, "CONFIG", "credentials: "+c)
+			}
+			result.Credentials = len(info.Credentials)
+			result.Partial = true
+			return finish(nil)
+		}
+
+		if onlyIndex {
+			entries, err := FetchIndex(pctx, hc, url, output, outputMode)
+			if err != nil {
+				return fail(wrapKind(ErrNothingRecovered, fmt.Errorf("fetching index: %w", err)))
+			}
+			for _, e := range entries {
+				logInfo(runID, "INDEX", "file", fmt.Sprintf("%06o %s %s", e.Mode, e.SHA, e.Name))
+			}
+			logWarning(runID, "INDEX", fmt.Sprintf("%d tracked files listed, no objects were downloaded so %s is incomplete", len(entries), output))
+			result.Partial = true
+			return finish(nil)
+		}
+
+		img, err = NewDockerImage(ctxroot, runID, url, output, BuildOptions{Host: dockerHost, AuthConfigs: auths, Trace: traceOut, CachePolicy: cachePolicy, ProxyEnv: proxyEnv, Dockerfile: dockerfile, Context: contextDir, KeepIntermediate: !rmIntermed})
+
+		if err != nil {
+			return fail(err)
+		}
+		if img.Reused {
+			logInfo(runID, "BUILD", "image", fmt.Sprintf("reused %.12s (%s)", img.ID, img.BuildDuration.Round(time.Millisecond)))
+		} else {
+			logInfo(runID, "BUILD", "image", fmt.Sprintf("built %.12s in %s", img.ID, img.BuildDuration.Round(time.Millisecond)))
+		}
+		if verbose {
+			host := img.Client.DaemonHost()
+			if dockerHost != "" {
+				host = dockerHost
+			}
+			logInfo(runID, "DOCKER", "host", host)
+			for _, h := range extraHosts {
+				logInfo(runID, "DOCKER", "add-host", h)
+			}
+			for _, ip := range dns {
+				logInfo(runID, "DOCKER", "dns", ip)
+			}
+		}
+
+		img.ContainerPath = path.Clean(containerPath)
+		img.EntrypointBin = entrypointBin
+		img.Name = name
+		img.Follow = follow
+		img.VolumeMode = volumeMode
+		img.AttachTimeout = attachTimeout
+		img.Heartbeat = heartbeat
+		img.KeepVolumes = keepVolumes
+		img.Env = proxyEnv
+		img.Labels = containerLabels
+		img.KillGrace = killGrace
+		img.KeepEntrypoint = keepEntry
+		img.ExtraHosts = extraHosts
+		img.ClientCert = clientCert
+		img.ClientKey = clientKey
+		img.DNS = dns
+		switch userns {
+		case "host":
+			img.UsernsMode = "host"
+		case "remap":
+			// remapping is configured on the daemon, all gget can do is check
+			remapped, err := img.IsUsernsRemapped(ctxroot)
+			if err != nil {
+				return fail(wrapKind(ErrDockerUnreachable, err))
+			}
+			if !remapped {
+				logWarning(runID, "DOCKER", "-userns remap was asked for but the daemon has no userns-remap configured, files will be owned by root")
+			}
+		}
+		// git-dumper's own defaults apply unless asked otherwise
+		if flagSet("request-retries") {
+			img.RequestRetries = retries
+		}
+		if flagSet("request-timeout") {
+			img.RequestTimeout = timeout
+		}
+		img.DumperArgs = append(append([]string{}, flag.Args()...), t.DumperArgs...)
+		daemonHost := dockerHost
+		if daemonHost == "" {
+			daemonHost = os.Getenv("DOCKER_HOST")
+		}
+		if daemonHost == "" {
+			daemonHost = img.Client.DaemonHost()
+		}
+		remote := isRemoteDaemon(daemonHost)
+		if remote && img.VolumeMode == VolumeModeBind && flagSet("volume-mode") {
+			// the daemon would create an empty directory of its own at that path
+			return fail(wrapKind(ErrValidation, fmt.Errorf("-volume-mode bind can't work with the remote daemon at %s, %s doesn't exist there; use -volume-mode named", daemonHost, output)))
+		}
+		if remote && clientCert != "" {
+			return fail(wrapKind(ErrValidation, fmt.Errorf("-client-cert is bind-mounted into the dump container, which the remote daemon at %s can't do", daemonHost)))
+		}
+		if remote {
+			// the post-dump steps run in helper containers that bind-mount -o,
+			// which doesn't exist on the remote host, or worse holds something else
+			var steps []string
+			for _, s := range []struct {
+				flag string
+				on   bool
+			}{
+				{"-branch", branch != ""},
+				{"-commit-times", commitTimes},
+				{"-mirror", mirror != ""},
+				{"-objects-dir-only", objectsOnly},
+				{"-verify-packs", verify && flagSet("verify-packs")},
+			} {
+				if s.on {
+					steps = append(steps, s.flag)
+				}
+			}
+			if len(steps) > 0 {
+				return fail(wrapKind(ErrValidation, fmt.Errorf("%s bind-mount %s into a helper container, which the remote daemon at %s can't see", strings.Join(steps, ", "), output, daemonHost)))
+			}
+			if verify {
+				logInfo(runID, "VERIFY", "skipped", "pack verification bind-mounts the output, which the remote daemon can't see")
+				verify = false
+			}
+		}
+		if !flagSet("volume-mode") && remote {
+			// a bind mount would land on the remote host, not this one
+			img.VolumeMode = VolumeModeNamed
+			logInfo(runID, "DOCKER", "volume-mode", "remote daemon, dumping into a named volume so the output reaches this host")
+		} else if !flagSet("volume-mode") {
+			desktop, err := img.IsDockerDesktop(ctxroot)
+			if err != nil {
+				return fail(wrapKind(ErrDockerUnreachable, err))
+			}
+			if desktop {
+				img.VolumeMode = VolumeModeNamed
+				logInfo(runID, "DOCKER", "volume-mode", "Docker Desktop detected, dumping into a named volume so the output reaches the host")
+			}
+		}
+
+		if socks5 != "" {
+			img.Socks5 = socks5
+			if err := img.CheckSocks5(ctxroot); err != nil {
+				return fail(err)
+			}
+		}
+
+		// delay before the first -retry-on-empty attempt, doubling after each
+		const emptyRetryDelay = 10 * time.Second
+		for attempt := 0; ; attempt++ {
+			chID := make(chan string, 1)
+			err = img.CreateContainer(ctxroot, chID)
+			if err != nil {
+				return fail(err)
+			}
+			id := <-chID
+			err = img.RunContainer(ctxroot, id)
+
+			entries, _ := os.ReadDir(output)
+			if len(entries) > 0 || !looksExposed || attempt >= retryOnEmpty || ctxroot.Err() != nil {
+				break
+			}
+			delay := emptyRetryDelay << attempt
+			logWarning(runID, "RUN", fmt.Sprintf("nothing was recovered though the probe found .git/HEAD, retrying in %s (%d of %d)", delay, attempt+1, retryOnEmpty))
+			result.EmptyRetries++
+			select {
+			case <-ctxroot.Done():
+				return fail(ctxroot.Err())
+			case <-time.After(delay):
+			}
+		}
+
+		if err != nil {
+			if clean {
+				if cerr := img.cleanDump(output, created, empty); cerr != nil {
+					return fail(fmt.Errorf("%w, and -clean-on-failure couldn't remove the partial output: %v", err, cerr))
+				}
+			}
+			return fail(err)
+		}
+
+		if nested, err := nestedRepo(output); err != nil {
+			logWarning(runID, "RUN", "flattening nested output: "+err.Error())
+		} else if nested != "" {
+			if err := img.flattenNested(ctxroot, output, nested); err != nil {
+				logWarning(runID, "RUN", "flattening nested output: "+err.Error())
+			} else {
+				logInfo(runID, "RUN", "flatten", "moved the repository up from "+filepath.Join(output, nested)+" into "+output)
+			}
+		}
+
+		if entries, err := os.ReadDir(output); err == nil && len(entries) == 0 {
+			logWarning(runID, "RUN", emptyOutputHint(img.VolumeMode, daemonHost))
+			return fail(wrapKind(ErrNothingRecovered, fmt.Errorf("git-dumper exited cleanly but %s is empty", output)))
+		}
+
+		if objectsOnly {
+			if err := img.ObjectsOnly(ctxroot); err != nil {
+				return fail(fmt.Errorf("narrowing the dump to .git/objects: %w", err))
+			}
+			logInfo(runID, "OBJECTS", "objects-dir-only", "kept only .git/objects, the refs, index, config and working tree were dropped")
+			result.Partial = true
+		}
+
+		objects, err := CountObjects(output)
+		if err != nil {
+			logWarning(runID, "OBJECTS", "counting objects: "+err.Error())
+		}
+		result.Objects = objects
+		logInfo(runID, "OBJECTS", "count", fmt.Sprintf("%d git objects recovered", objects))
+		if objects < minObjects {
+			msg := fmt.Sprintf("only %d git objects recovered, expected at least %d", objects, minObjects)
+			if requireMin {
+				return fail(wrapKind(ErrNothingRecovered, errors.New(msg)))
+			}
+			logWarning(runID, "OBJECTS", msg)
+		}
+
+		if verify {
+			n, broken, err := img.VerifyPacks(ctxroot)
+			if err != nil {
+				return fail(err)
+			}
+			for _, b := range broken {
+				logError(runID, "VERIFY", b)
+			}
+			if len(broken) > 0 {
+				return fail(wrapKind(ErrRunFailed, fmt.Errorf("%d of %d pack files are incomplete or corrupt", len(broken), n)))
+			}
+			logInfo(runID, "VERIFY", "packs", fmt.Sprintf("%d pack files verified", n))
+		}
+
+		if branch != "" {
+			if err := img.Checkout(ctxroot, branch); err != nil {
+				return fail(err)
+			}
+			logInfo(runID, "CHECKOUT", "branch", branch)
+		}
+
+		if commitTimes {
+			updated, untouched, err := img.CommitTimes(ctxroot)
+			if err != nil {
+				return fail(err)
+			}
+			logInfo(runID, "TIMES", "files", fmt.Sprintf("%d files set to their commit time, %d without history left as is", updated, untouched))
+		}
+
+		if mirror != "" {
+			refs, newCommits, err := img.Mirror(ctxroot, mirror, sinceCommit)
+			if err != nil {
+				return fail(err)
+			}
+			for _, ref := range refs {
+				logInfo(runID, "MIRROR", "ref", ref)
+			}
+			if sinceCommit != "" {
+				logInfo(runID, "MIRROR", "since", fmt.Sprintf("%d new commits since %s", newCommits, sinceCommit))
+			}
+		}
+
+		if outputTree {
+			if err := PrintTree(os.Stdout, output, treeDepth); err != nil {
+				return fail(err)
+			}
+		}
+
+		if writeManifest {
+			dest := manifestPath
+			if dest == "" {
+				dest = filepath.Join(output, manifestName)
+			}
+			if err := WriteManifest(dest, output, runID, url); err != nil {
+				return fail(fmt.Errorf("writing manifest: %w", err))
+			}
+			logInfo(runID, "MANIFEST", "path", dest)
+		}
+
+		if diffAgainst != "" {
+			prev, found, err := loadBaseline(diffAgainst)
+			if err != nil {
+				return fail(fmt.Errorf("reading -diff-against baseline: %w", err))
+			}
+			if !found {
+				logWarning(runID, "DIFF", diffAgainst+" doesn't exist yet, every file counts as added")
+			}
+			cur, err := buildManifest(output, filepath.Join(output, manifestName), runID, url)
+			if err != nil {
+				return fail(fmt.Errorf("listing output for -diff-against: %w", err))
+			}
+			d := diffManifests(prev, cur)
+			d.Against = diffAgainst
+			logInfo(runID, "DIFF", "summary", fmt.Sprintf("%d added, %d removed, %d changed since %s", len(d.Added), len(d.Removed), len(d.Changed), diffAgainst))
+			if diffJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(d); err != nil {
+					return fail(err)
+				}
+			} else if verbose {
+				for _, p := range d.Added {
+					logInfo(runID, "DIFF", "added", p)
+				}
+				for _, p := range d.Removed {
+					logInfo(runID, "DIFF", "removed", p)
+				}
+				for _, p := range d.Changed {
+					logInfo(runID, "DIFF", "changed", p)
+				}
+			}
+		}
+
+		if ownerUID >= 0 {
+			if err := ChownTree(output, ownerUID, ownerGID); err != nil {
+				return fail(err)
+			}
+			logInfo(runID, "OWNER", "chown", fmt.Sprintf("%s now owned by %d:%d", output, ownerUID, ownerGID))
+		}
+
+		if uploadTo != nil {
+			// the dump itself succeeded, a failed upload is reported apart from it
+			dest, err := uploadTo.Upload(ctxroot, output, url, runID)
+			if err != nil {
+				result.UploadErr = err
+				logWarning(runID, "UPLOAD", err.Error())
+			} else {
+				result.Upload = dest
+				logInfo(runID, "UPLOAD", "dest", dest)
+			}
+		}
+
+		if postHook != "" {
+			if err := RunHook(ctxroot, postHook, hookTimeout, result, img.ExitCode); err != nil {
+				if hookRequired {
+					return fail(err)
+				}
+				logWarning(runID, "HOOK", err.Error())
+			}
+		}
+
+		return finish(nil)
+	}
+
+	var results []Result
+	for _, t := range targets {
+		// an interrupt ends the whole run, not just the dump it arrived during
+		if ctxroot.Err() != nil {
+			break
+		}
+		r := dump(t)
+		results = append(results, r)
+		// every target builds the same image, so a failed build usually fails them all
+		if errors.Is(r.Err, ErrBuildFailed) && !keepGoing && len(results) < len(targets) {
+			log.Printf("image build failed, skipping the remaining %d targets, pass -keep-going-after-build-failure to try them anyway", len(targets)-len(results))
+			break
+		}
+	}
+	if summaryOnly {
+		WriteSummary(os.Stdout, results)
+	}
+	if reportCSV != "" {
+		if err := WriteReportCSV(reportCSV, results); err != nil {
+			log.Println(err)
+		}
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			os.Exit(1)
+		}
 	}
 }
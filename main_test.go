@@ -0,0 +1,484 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/docker/docker/client"
+)
+
+// a docker client talking to handler instead of a daemon, along with every
+// "METHOD /path" it was sent, without the api version prefix
+func fakeDaemon(t *testing.T, handler http.HandlerFunc) (*client.Client, func() []string) {
+	t.Helper()
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if i := strings.Index(path[1:], "/"); strings.HasPrefix(path, "/v") && i >= 0 {
+			path = path[i+1:]
+		}
+		mu.Lock()
+		calls = append(calls, r.Method+" "+path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if handler == nil {
+			http.Error(w, `{"message":"not implemented"}`, http.StatusNotImplemented)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	cli, err := client.NewClientWithOpts(
+		client.WithHost("tcp://"+strings.TrimPrefix(srv.URL, "http://")),
+		client.WithHTTPClient(srv.Client()),
+		client.WithVersion("1.41"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, calls...)
+	}
+}
+
+func TestCreateContainerCanceled(t *testing.T) {
+	for _, mode := range []string{VolumeModeBind, VolumeModeNamed} {
+		t.Run(mode, func(t *testing.T) {
+			cli, calls := fakeDaemon(t, nil)
+			di := &DockerImage{Client: cli, RunID: "test", VolumeMode: mode, ContainerPath: "/git", EntrypointBin: "git-dumper"}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			chID := make(chan string, 1)
+			if err := di.CreateContainer(ctx, chID); err != context.Canceled {
+				t.Fatalf("CreateContainer returned %v, want context.Canceled", err)
+			}
+			if id, ok := <-chID; ok {
+				t.Fatalf("got container id %q, want the channel closed", id)
+			}
+			if c := calls(); len(c) != 0 {
+				t.Fatalf("the daemon was called: %v", c)
+			}
+		})
+	}
+}
+
+func TestWaitContainer(t *testing.T) {
+	tests := []struct {
+		name string
+		// response to POST /containers/{id}/wait, a status code >= 400 fails it
+		waitStatus int
+		waitBody   string
+		// response to GET /containers/{id}/json once wait failed
+		inspect  string
+		wantCode int
+		wantErr  bool
+		wantPoll bool
+	}{
+		{name: "clean exit", waitStatus: 200, waitBody: `{"StatusCode":0}`},
+		{name: "non-zero exit", waitStatus: 200, waitBody: `{"StatusCode":3}`, wantCode: 3},
+		{name: "exit with error", waitStatus: 200, waitBody: `{"StatusCode":1,"Error":{"Message":"boom"}}`, wantCode: 1, wantErr: true},
+		{name: "wait fails, poll", waitStatus: 500, waitBody: `{"message":"no wait here"}`, inspect: `{"State":{"Status":"exited","ExitCode":2}}`, wantCode: 2, wantPoll: true},
+		{name: "wait fails, poll errors", waitStatus: 500, waitBody: `{"message":"no wait here"}`, inspect: `{"State":{"Status":"exited","ExitCode":137,"Error":"oom"}}`, wantCode: 137, wantErr: true, wantPoll: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, calls := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/wait"):
+					w.WriteHeader(tt.waitStatus)
+					w.Write([]byte(tt.waitBody))
+				case strings.HasSuffix(r.URL.Path, "/json"):
+					w.Write([]byte(tt.inspect))
+				default:
+					http.NotFound(w, r)
+				}
+			})
+			di := &DockerImage{Client: cli, RunID: "test"}
+			code, err := di.WaitContainer(context.Background(), "abc")
+			if code != tt.wantCode || (err != nil) != tt.wantErr {
+				t.Fatalf("got %d, %v, want %d and an error: %t", code, err, tt.wantCode, tt.wantErr)
+			}
+			polled := false
+			for _, c := range calls() {
+				polled = polled || c == "GET /containers/abc/json"
+			}
+			if polled != tt.wantPoll {
+				t.Fatalf("polled: %t, want %t (%v)", polled, tt.wantPoll, calls())
+			}
+		})
+	}
+}
+
+// a broken build context is reported before the daemon is asked to build
+func TestNewDockerImageBadContext(t *testing.T) {
+	cli, calls := fakeDaemon(t, nil)
+	bad := fstest.MapFS{"Dockerfile.tar.gz": &fstest.MapFile{Data: []byte{}}}
+	_, err := NewDockerImage(context.Background(), "test", "http://example.com/.git", t.TempDir(), BuildOptions{Host: cli.DaemonHost(), ContextFS: bad})
+	if err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Fatalf("got %v, want the empty context reported", err)
+	}
+	if c := calls(); len(c) != 0 {
+		t.Fatalf("the daemon was called: %v", c)
+	}
+}
+
+// an in-memory context stands in for the embedded one, all the way to the
+// daemon's build endpoint
+func TestNewDockerImageContextFS(t *testing.T) {
+	var sent string
+	cli, _ := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/images/feedface/json") {
+			w.Write([]byte(`{"Id":"sha256:feedface","Os":"linux"}`))
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/build") {
+			http.NotFound(w, r)
+			return
+		}
+		tr := tar.NewReader(r.Body)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			if hdr.Name == "Dockerfile" {
+				data, _ := io.ReadAll(tr)
+				sent = string(data)
+			}
+		}
+		w.Write([]byte(`{"stream":"Step 1/1 : FROM fake\n"}` + "\n" + `{"aux":{"ID":"sha256:feedface"}}` + "\n"))
+	})
+	var ctx bytes.Buffer
+	tw := tar.NewWriter(&ctx)
+	tw.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0644, Size: int64(len("FROM fake\n"))})
+	tw.Write([]byte("FROM fake\n"))
+	tw.Close()
+
+	fsys := fstest.MapFS{"Dockerfile.tar.gz": &fstest.MapFile{Data: ctx.Bytes()}}
+	img, err := NewDockerImage(context.Background(), "test", "http://example.com/.git", t.TempDir(), BuildOptions{Host: cli.DaemonHost(), ContextFS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ID != "feedface" {
+		t.Errorf("image id is %q, want feedface", img.ID)
+	}
+	if sent != "FROM fake\n" {
+		t.Errorf("the daemon was sent the Dockerfile %q, want the in-memory one", sent)
+	}
+}
+
+func TestRemoveOptions(t *testing.T) {
+	tests := []struct {
+		mode          string
+		keepVolumes   bool
+		removeVolumes bool
+	}{
+		{VolumeModeBind, false, true},
+		{VolumeModeBind, true, false},
+		// the named output volume is removed separately, after the copy-out
+		{VolumeModeNamed, false, false},
+		{VolumeModeNamed, true, false},
+	}
+	for _, tt := range tests {
+		di := &DockerImage{VolumeMode: tt.mode, KeepVolumes: tt.keepVolumes}
+		opts := di.removeOptions()
+		if opts.RemoveVolumes != tt.removeVolumes || !opts.Force {
+			t.Errorf("%s, keep volumes %t: got %+v", tt.mode, tt.keepVolumes, opts)
+		}
+	}
+}
+
+// sets up a fresh command line with the given flags defined and args parsed,
+// restoring the real one afterwards
+func testFlags(t *testing.T, names []string, args ...string) {
+	t.Helper()
+	saved := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = saved })
+	flag.CommandLine = flag.NewFlagSet("gget", flag.ContinueOnError)
+	for _, name := range names {
+		if flag.Lookup(name) == nil {
+			flag.String(name, "", "")
+		}
+	}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckFlagConflicts(t *testing.T) {
+	for _, c := range flagConflicts {
+		t.Run(c.a+" "+c.b, func(t *testing.T) {
+			testFlags(t, []string{c.a, c.b})
+			if err := checkFlagConflicts(); err != nil {
+				t.Fatalf("defaults conflict: %v", err)
+			}
+			testFlags(t, []string{c.a, c.b}, "-"+c.a+"=x")
+			if err := checkFlagConflicts(); err != nil && strings.Contains(err.Error(), "used together") {
+				t.Fatalf("one flag alone conflicts: %v", err)
+			}
+			testFlags(t, []string{c.a, c.b}, "-"+c.a+"=x", "-"+c.b+"=x")
+			err := checkFlagConflicts()
+			if err == nil || !strings.Contains(err.Error(), "-"+c.a+" and -"+c.b) {
+				t.Fatalf("got %v, want -%s and -%s rejected", err, c.a, c.b)
+			}
+		})
+	}
+	// a flag explicitly turned off doesn't count
+	testFlags(t, []string{"only-index", "mirror"}, "-only-index=false", "-mirror=x")
+	if err := checkFlagConflicts(); err != nil {
+		t.Fatalf("a disabled flag conflicts: %v", err)
+	}
+}
+
+func TestCheckFlagRequires(t *testing.T) {
+	for _, r := range flagRequires {
+		alternatives := strings.Split(r.needs, "|")
+		names := append([]string{r.flag}, alternatives...)
+		t.Run(r.flag, func(t *testing.T) {
+			testFlags(t, names, "-"+r.flag+"=x")
+			err := checkFlagConflicts()
+			if err == nil || !strings.HasPrefix(err.Error(), "-"+r.flag+" has no effect without -"+alternatives[0]) {
+				t.Fatalf("got %v, want -%s rejected without -%s", err, r.flag, r.needs)
+			}
+			for _, needs := range alternatives {
+				testFlags(t, names, "-"+r.flag+"=x", "-"+needs+"=x")
+				if err := checkFlagConflicts(); err != nil && strings.HasPrefix(err.Error(), "-"+r.flag+" has no effect") {
+					t.Fatalf("-%s with -%s: %v", r.flag, needs, err)
+				}
+			}
+		})
+	}
+}
+
+func TestIsRemoteDaemon(t *testing.T) {
+	tests := []struct {
+		host   string
+		remote bool
+	}{
+		{"", false},
+		{"unix:///var/run/docker.sock", false},
+		{"npipe:////./pipe/docker_engine", false},
+		{"tcp://localhost:2375", false},
+		{"tcp://127.0.0.1:2375", false},
+		{"tcp://127.0.0.53:2376", false},
+		{"tcp://[::1]:2375", false},
+		{"tcp://10.0.0.5:2376", true},
+		{"tcp://docker.internal:2376", true},
+		{"tcp://[2001:db8::1]:2376", true},
+		{"ssh://me@bastion", true},
+		{"ssh://localhost", true},
+	}
+	for _, tt := range tests {
+		if got := isRemoteDaemon(tt.host); got != tt.remote {
+			t.Errorf("isRemoteDaemon(%q) = %t, want %t", tt.host, got, tt.remote)
+		}
+	}
+}
+
+func TestDumperHeaders(t *testing.T) {
+	tests := []struct {
+		args []string
+		want http.Header
+	}{
+		{nil, http.Header{}},
+		{[]string{"-j", "4", "-r", "2"}, http.Header{}},
+		{[]string{"-H", "Authorization = Bearer abc=="}, http.Header{"Authorization": {"Bearer abc=="}}},
+		{[]string{"--header=X-Api-Key=1", "--header", "Cookie=a=b"}, http.Header{"X-Api-Key": {"1"}, "Cookie": {"a=b"}}},
+		// a -u value is a user agent, not an option
+		{[]string{"-u", "-H", "-j", "4"}, http.Header{}},
+	}
+	for _, tt := range tests {
+		if got := dumperHeaders(tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dumperHeaders(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDumperArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		ok   bool
+	}{
+		{nil, true},
+		{[]string{"-j", "4"}, true},
+		{[]string{"--jobs", "4", "-r", "2"}, true},
+		{[]string{"-H", "Authorization=Basic Zm9v"}, true},
+		{[]string{"--header=Authorization=Basic Zm9v"}, true},
+		{[]string{"-H", "Authorization: Basic Zm9v"}, false},
+		{[]string{"--header", "=Basic Zm9v"}, false},
+		{[]string{"http://example.com/.git"}, false},
+		{[]string{"-j"}, false},
+		{[]string{"-j", "4", "out"}, false},
+		{[]string{"-"}, false},
+	}
+	for _, tt := range tests {
+		if err := validateDumperArgs(tt.args); (err == nil) != tt.ok {
+			t.Errorf("validateDumperArgs(%q) = %v, want ok: %t", tt.args, err, tt.ok)
+		}
+	}
+}
+
+func TestCleanDump(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can remove files whatever their permissions")
+	}
+	tests := []struct {
+		name string
+		mode string
+		// whether the dump left a directory the host can't write to
+		locked bool
+		// exit status of the helper container
+		helperStatus int
+		wantHelper   bool
+		wantErr      bool
+	}{
+		{name: "owned by the caller", mode: VolumeModeBind},
+		{name: "owned by root", mode: VolumeModeBind, locked: true, wantHelper: true},
+		{name: "helper fails", mode: VolumeModeBind, locked: true, helperStatus: 1, wantHelper: true, wantErr: true},
+		{name: "named volume", mode: VolumeModeNamed, locked: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "out")
+			objects := filepath.Join(output, ".git", "objects")
+			if err := os.MkdirAll(objects, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(objects, "pack"), []byte("x"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.locked {
+				os.Chmod(objects, 0555)
+				t.Cleanup(func() { os.Chmod(objects, 0755) })
+			}
+			cli, calls := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/containers/create"):
+					w.Write([]byte(`{"Id":"helper"}`))
+				case strings.HasSuffix(r.URL.Path, "/start"):
+					// the helper runs as root, which the permissions don't stop
+					if tt.helperStatus == 0 {
+						os.Chmod(objects, 0755)
+						os.RemoveAll(filepath.Join(output, ".git"))
+					}
+					w.WriteHeader(http.StatusNoContent)
+				case strings.HasSuffix(r.URL.Path, "/wait"):
+					fmt.Fprintf(w, `{"StatusCode":%d}`, tt.helperStatus)
+				case strings.HasSuffix(r.URL.Path, "/logs"):
+				case r.Method == http.MethodDelete:
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					http.NotFound(w, r)
+				}
+			})
+			di := &DockerImage{Client: cli, RunID: "test", SourceDir: output, VolumeMode: tt.mode}
+			err := di.cleanDump(output, true, true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cleanDump returned %v", err)
+			}
+			helper := false
+			for _, c := range calls() {
+				helper = helper || c == "POST /containers/create"
+			}
+			if helper != tt.wantHelper {
+				t.Fatalf("helper container started: %t, want %t (%v)", helper, tt.wantHelper, calls())
+			}
+			if _, err := os.Stat(output); !tt.wantErr && !os.IsNotExist(err) {
+				t.Fatalf("%s is still there", output)
+			}
+		})
+	}
+}
+
+func TestNestedRepo(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+		fails bool
+	}{
+		{name: "already flat", files: []string{".git/HEAD", "README"}},
+		{name: "nested", files: []string{"repo/.git/HEAD", "repo/README"}, want: "repo"},
+		{name: "no .git below", files: []string{"repo/README"}},
+		{name: "more than one directory", files: []string{"a/.git/HEAD", "b/.git/HEAD"}},
+		{name: "entry named like its directory", files: []string{"repo/.git/HEAD", "repo/repo"}, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := t.TempDir()
+			for _, name := range tt.files {
+				p := filepath.Join(output, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := nestedRepo(output)
+			if got != tt.want || (err != nil) != tt.fails {
+				t.Fatalf("got %q, %v, want %q and an error: %t", got, err, tt.want, tt.fails)
+			}
+		})
+	}
+}
+
+func TestFlattenNested(t *testing.T) {
+	for _, mode := range []string{VolumeModeBind, VolumeModeNamed} {
+		t.Run(mode, func(t *testing.T) {
+			output := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(output, "repo", ".git"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			var created struct{ Entrypoint []string }
+			cli, calls := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/containers/create"):
+					json.NewDecoder(r.Body).Decode(&created)
+					w.Write([]byte(`{"Id":"helper"}`))
+				case strings.HasSuffix(r.URL.Path, "/wait"):
+					w.Write([]byte(`{"StatusCode":0}`))
+				case strings.HasSuffix(r.URL.Path, "/logs"):
+				default:
+					w.WriteHeader(http.StatusNoContent)
+				}
+			})
+			di := &DockerImage{Client: cli, RunID: "test", SourceDir: output, VolumeMode: mode}
+			if err := di.flattenNested(context.Background(), output, "repo"); err != nil {
+				t.Fatal(err)
+			}
+			if mode == VolumeModeNamed {
+				// copied out by gget, so moved on the host
+				if len(calls()) != 0 {
+					t.Fatalf("a container was used: %v", calls())
+				}
+				if _, err := os.Stat(filepath.Join(output, ".git")); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if want := []string{"sh", "-c", flattenScript, "sh", "repo"}; !reflect.DeepEqual(created.Entrypoint, want) {
+				t.Fatalf("the helper container ran %q, want %q", created.Entrypoint, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// name of the manifest written into the output directory by -manifest
+const manifestName = "gget-manifest.json"
+
+type manifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	// symlinks are recorded with their target rather than a hash
+	Link string `json:"link,omitempty"`
+}
+
+type manifest struct {
+	RunID     string         `json:"run_id"`
+	URL       string         `json:"url"`
+	Generated time.Time      `json:"generated"`
+	Files     []manifestFile `json:"files"`
+}
+
+// hashes every file under output and writes the list to path, replacing it
+// in one step so a reader never sees half a manifest. path itself is left
+// out when it's inside output.
+func WriteManifest(path string, output string, runID string, url string) error {
+	m, err := buildManifest(output, path, runID, url)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".gget-manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	enc := json.NewEncoder(tmp)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// lists every file under output except skip
+func buildManifest(output string, skip string, runID string, url string) (manifest, error) {
+	m := manifest{RunID: runID, URL: redactURL(url), Generated: time.Now().UTC(), Files: []manifestFile{}}
+	err := filepath.WalkDir(output, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || p == skip {
+			return nil
+		}
+		rel, err := filepath.Rel(output, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f := manifestFile{Path: filepath.ToSlash(rel), Size: info.Size()}
+		if d.Type()&fs.ModeSymlink != 0 {
+			if f.Link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		} else if f.SHA256, err = hashFile(p); err != nil {
+			return err
+		}
+		m.Files = append(m.Files, f)
+		return nil
+	})
+	return m, err
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parses the uid:gid given to -output-owner, both numeric since the names
+// inside and outside of a container rarely agree
+func parseOwner(s string) (int, int, error) {
+	u, g, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("owner must be uid:gid, got %q", s)
+	}
+	uid, err := strconv.ParseUint(u, 10, 31)
+	if err != nil {
+		return 0, 0, fmt.Errorf("owner must be uid:gid, uid %q isn't a number", u)
+	}
+	gid, err := strconv.ParseUint(g, 10, 31)
+	if err != nil {
+		return 0, 0, fmt.Errorf("owner must be uid:gid, gid %q isn't a number", g)
+	}
+	return int(uid), int(gid), nil
+}
+
+// hands root and everything under it to uid:gid. Symlinks are changed
+// themselves rather than what they point at, which may be outside root.
+func ChownTree(root string, uid int, gid int) error {
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, uid, gid)
+	})
+	if errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("changing the owner of %s needs root or CAP_CHOWN: %w", root, err)
+	}
+	return err
+}
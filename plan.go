@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// a file a dump would download, Size is -1 when the server didn't say
+type planFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// what -plan found a dump would start from
+type fetchPlan struct {
+	URL   string     `json:"url"`
+	Refs  []string   `json:"refs"`
+	Packs []planFile `json:"packs"`
+	// the objects refs point at that are stored loose
+	Loose []planFile `json:"loose"`
+	// sum of the sizes that are known, and how many files had none
+	Size    int64 `json:"size"`
+	Unknown int   `json:"unknown"`
+}
+
+// lists the packs objects/info/packs announces and the loose objects the refs
+// point at, with sizes from HEAD requests, without downloading any of them.
+// Objects further back in history are only found by walking the commits, so
+// the plan is a lower bound for a repository that isn't fully packed.
+func PlanFetch(ctx context.Context, hc *http.Client, url string) (fetchPlan, error) {
+	base := gitBaseURL(url)
+	plan := fetchPlan{URL: url}
+	refs, _ := ListRefs(ctx, hc, url)
+	plan.Refs = refs
+
+	if data, err := fetch(ctx, hc, base+"objects/info/packs"); err == nil {
+		s := bufio.NewScanner(bytes.NewReader(data))
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) != 2 || fields[0] != "P" || !strings.HasSuffix(fields[1], ".pack") {
+				continue
+			}
+			for _, name := range []string{fields[1], strings.TrimSuffix(fields[1], ".pack") + ".idx"} {
+				p := "objects/pack/" + name
+				size, ok, err := contentLength(ctx, hc, base+p)
+				if err != nil {
+					return plan, err
+				}
+				if ok {
+					plan.Packs = append(plan.Packs, planFile{Path: p, Size: size})
+				}
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		sha := strings.Fields(ref)[0]
+		if len(sha) != 40 || seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		p := "objects/" + sha[:2] + "/" + sha[2:]
+		size, ok, err := contentLength(ctx, hc, base+p)
+		if err != nil {
+			return plan, err
+		}
+		// missing means it's in a pack
+		if ok {
+			plan.Loose = append(plan.Loose, planFile{Path: p, Size: size})
+		}
+	}
+
+	for _, f := range append(append([]planFile{}, plan.Packs...), plan.Loose...) {
+		if f.Size < 0 {
+			plan.Unknown++
+		} else {
+			plan.Size += f.Size
+		}
+	}
+	if len(plan.Refs) == 0 && len(plan.Packs) == 0 {
+		return plan, fmt.Errorf("no refs or packs found under %s", base)
+	}
+	return plan, nil
+}
+
+// asks for url's size with a HEAD request. A missing file isn't an error, it
+// just reports false.
+func contentLength(ctx context.Context, hc *http.Client, url string) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, nil
+	}
+	return resp.ContentLength, true, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// matches entries of an html directory listing, skipping parent, absolute and
+// sort links. Ref names can't start with a dot so nothing real is lost.
+var hrefPattern = regexp.MustCompile(`href="([^"?/.][^"?]*)"`)
+
+// returns the url of the exposed .git directory with a trailing slash
+func gitBaseURL(url string) string {
+	url = strings.TrimRight(url, "/")
+	if !strings.HasSuffix(url, ".git") {
+		url += "/.git"
+	}
+	return url + "/"
+}
+
+func fetch(ctx context.Context, hc *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// enumerates branches and tags from packed-refs and, where the server allows
+// directory listing, the refs/ tree. Every source is best-effort; refs are
+// returned as "<sha> <refname>"
+func ListRefs(ctx context.Context, hc *http.Client, url string) ([]string, error) {
+	base := gitBaseURL(url)
+	found := map[string]string{}
+
+	if data, err := fetch(ctx, hc, base+"packed-refs"); err == nil {
+		s := bufio.NewScanner(bytes.NewReader(data))
+		for s.Scan() {
+			line := s.Text()
+			if line == "" || line[0] == '#' || line[0] == '^' {
+				continue
+			}
+			if fields := strings.Fields(line); len(fields) == 2 {
+				found[fields[1]] = fields[0]
+			}
+		}
+	}
+	for _, dir := range []string{"refs/heads/", "refs/tags/"} {
+		listRefDir(ctx, hc, base, dir, found, 0)
+	}
+
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no refs found under %s", base)
+	}
+	refs := make([]string, 0, len(found))
+	for name, sha := range found {
+		refs = append(refs, sha+" "+name)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return strings.Fields(refs[i])[1] < strings.Fields(refs[j])[1]
+	})
+	return refs, nil
+}
+
+// walks a listed refs directory, reading each loose ref it finds
+func listRefDir(ctx context.Context, hc *http.Client, base string, dir string, found map[string]string, depth int) {
+	// ref names rarely nest deeper than a few components
+	if depth > 4 {
+		return
+	}
+	data, err := fetch(ctx, hc, base+dir)
+	if err != nil {
+		return
+	}
+	for _, m := range hrefPattern.FindAllSubmatch(data, -1) {
+		name := string(m[1])
+		if strings.HasSuffix(name, "/") {
+			listRefDir(ctx, hc, base, dir+name, found, depth+1)
+			continue
+		}
+		sha, err := fetch(ctx, hc, base+dir+name)
+		if err != nil {
+			continue
+		}
+		found[dir+name] = strings.TrimSpace(string(sha))
+	}
+}
+
+// what the last response to the HEAD probe said about the server
+type probeResponse struct {
+	Status int
+	Server string
+}
+
+// fetches .git/HEAD with header, following at most maxRedirects redirects,
+// and returns why the target looks gated behind a login, or "" when it
+// doesn't, along with the final response's status and Server header. Failing to reach the target
+// at all is returned as an error and isn't a reason to skip, the dump may
+// still get through where the probe didn't.
+func ProbeGated(ctx context.Context, hc *http.Client, url string, maxRedirects int, header http.Header) (string, probeResponse, error) {
+	head := gitBaseURL(url) + "HEAD"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, head, nil)
+	if err != nil {
+		return "", probeResponse{}, err
+	}
+	// set on the request rather than the transport, so a redirect to another
+	// host doesn't get the credentials
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	probe := *hc
+	probe.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+	resp, err := probe.Do(req)
+	if err != nil {
+		return "", probeResponse{}, err
+	}
+	defer resp.Body.Close()
+	info := probeResponse{Status: resp.StatusCode, Server: resp.Header.Get("Server")}
+
+	final := resp.Request.URL
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		location, err := resp.Location()
+		if err != nil {
+			return fmt.Sprintf("%s answered %s without a location", head, resp.Status), info, nil
+		}
+		if location.Host == req.URL.Host {
+			return fmt.Sprintf("%s redirects more than %d times", head, maxRedirects), info, nil
+		}
+		final = location
+	}
+	if final.Host != req.URL.Host {
+		return fmt.Sprintf("%s redirects to another host, %s", head, final.Redacted()), info, nil
+	}
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Sprintf("%s answered %s", head, resp.Status), info, nil
+	case http.StatusOK:
+		// HEAD is a line of text, a page of html is a login form or a catch-all
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if strings.Contains(resp.Header.Get("Content-Type"), "html") || bytes.HasPrefix(bytes.TrimSpace(body), []byte("<")) {
+			return fmt.Sprintf("%s is an html page, likely a login form at %s", head, final.Redacted()), info, nil
+		}
+	}
+	return "", info, nil
+}
+
+// reports whether the server lists the .git directory. git-dumper notices
+// this itself and downloads recursively instead of guessing object names,
+// which makes for a far more complete dump.
+func ProbeListing(ctx context.Context, hc *http.Client, url string) (bool, error) {
+	data, err := fetch(ctx, hc, gitBaseURL(url))
+	if err != nil {
+		return false, err
+	}
+	names := map[string]bool{}
+	for _, m := range hrefPattern.FindAllSubmatch(data, -1) {
+		names[strings.TrimSuffix(string(m[1]), "/")] = true
+	}
+	return names["HEAD"] && names["objects"], nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbeGated(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ref: refs/heads/main")
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/open/.git/HEAD":
+			fmt.Fprintln(w, "ref: refs/heads/main")
+		case "/login/.git/HEAD":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintln(w, "<html><form>")
+		case "/auth/.git/HEAD":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, "no", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintln(w, "ref: refs/heads/main")
+		case "/away/.git/HEAD":
+			http.Redirect(w, r, other.URL+"/.git/HEAD", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		gated  bool
+		status int
+	}{
+		{"exposed", "/open", nil, false, http.StatusOK},
+		{"login page", "/login", nil, true, http.StatusOK},
+		{"unauthorized", "/auth", nil, true, http.StatusUnauthorized},
+		{"with credentials", "/auth", http.Header{"Authorization": {"Bearer secret"}}, false, http.StatusOK},
+		{"wrong credentials", "/auth", http.Header{"Authorization": {"Bearer guess"}}, true, http.StatusUnauthorized},
+		{"other host", "/away", nil, true, http.StatusOK},
+		{"missing", "/gone", nil, false, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gated, info, err := ProbeGated(context.Background(), srv.Client(), srv.URL+tt.path, 5, tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (gated != "") != tt.gated || info.Status != tt.status {
+				t.Fatalf("got %q with status %d, want gated %t with status %d", gated, info.Status, tt.gated, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/errdefs"
+)
+
+// removes every stopped container and unused volume carrying labelRunID, and
+// the images carrying labelImage. Runs clean up their containers and volumes,
+// so those were left by one that was killed, images are kept until pruned.
+// What a run still in progress is using is left alone unless force is set,
+// like `docker container prune` does.
+func Prune(ctx context.Context, host string, dryRun bool, force bool) error {
+	cli, err := newClient(host, "", nil)
+	if err != nil {
+		return wrapKind(ErrDockerUnreachable, err)
+	}
+	labelled := filters.NewArgs(filters.Arg("label", labelRunID))
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: labelled})
+	if err != nil {
+		return wrapKind(ErrDockerUnreachable, err)
+	}
+	// the volumes and images of the containers that are kept
+	inUse := map[string]bool{}
+	for _, c := range containers {
+		runID := c.Labels[labelRunID]
+		if !force && c.State != "exited" && c.State != "created" && c.State != "dead" {
+			logInfo(runID, "PRUNE", "container", fmt.Sprintf("kept %.12s, it is %s, -force removes it anyway", c.ID, c.State))
+			for _, m := range c.Mounts {
+				if m.Type == mount.TypeVolume {
+					inUse[m.Name] = true
+				}
+			}
+			inUse[c.ImageID] = true
+			continue
+		}
+		if dryRun {
+			logInfo(runID, "PRUNE", "container", fmt.Sprintf("would remove %.12s (%s)", c.ID, c.State))
+			continue
+		}
+		if err := cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{RemoveVolumes: true, Force: true}); err != nil {
+			return err
+		}
+		logInfo(runID, "PRUNE", "container", fmt.Sprintf("removed %.12s", c.ID))
+	}
+
+	// volumes go second, a container still holding one would block its removal
+	volumes, err := cli.VolumeList(ctx, labelled)
+	if err != nil {
+		return wrapKind(ErrDockerUnreachable, err)
+	}
+	for _, v := range volumes.Volumes {
+		runID := v.Labels[labelRunID]
+		if inUse[v.Name] {
+			logInfo(runID, "PRUNE", "volume", "kept "+v.Name+", a running container uses it")
+			continue
+		}
+		if dryRun {
+			logInfo(runID, "PRUNE", "volume", "would remove "+v.Name)
+			continue
+		}
+		// without force the daemon refuses a volume some other container uses
+		err := cli.VolumeRemove(ctx, v.Name, force)
+		if errdefs.IsConflict(err) {
+			logInfo(runID, "PRUNE", "volume", "kept "+v.Name+", it is in use")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		logInfo(runID, "PRUNE", "volume", "removed "+v.Name)
+	}
+
+	// images go last, the removed containers no longer hold them
+	images, err := cli.ImageList(ctx, types.ImageListOptions{Filters: filters.NewArgs(filters.Arg("label", labelImage))})
+	if err != nil {
+		return wrapKind(ErrDockerUnreachable, err)
+	}
+	for _, img := range images {
+		id := strings.TrimPrefix(img.ID, "sha256:")
+		if inUse[img.ID] {
+			logInfo("", "PRUNE", "image", fmt.Sprintf("kept %.12s, a running container uses it", id))
+			continue
+		}
+		if dryRun {
+			logInfo("", "PRUNE", "image", fmt.Sprintf("would remove %.12s", id))
+			continue
+		}
+		// forced so an image tagged more than once goes in one call, the
+		// daemon still refuses one a running container uses
+		_, err := cli.ImageRemove(ctx, img.ID, types.ImageRemoveOptions{Force: true, PruneChildren: true})
+		if errdefs.IsConflict(err) {
+			logInfo("", "PRUNE", "image", fmt.Sprintf("kept %.12s, it is in use", id))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		logInfo("", "PRUNE", "image", fmt.Sprintf("removed %.12s", id))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  string
+		dryRun bool
+		force  bool
+		// status the daemon answers DELETE /volumes with
+		volumeStatus int
+		want         []string
+	}{
+		{
+			name:   "dry run",
+			state:  "exited",
+			dryRun: true,
+			want:   []string{"GET /containers/json", "GET /volumes", "GET /images/json"},
+		},
+		{
+			name:  "remove",
+			state: "exited",
+			want: []string{
+				"GET /containers/json", "DELETE /containers/c0ffee",
+				"GET /volumes", "DELETE /volumes/gget-run",
+				"GET /images/json", "DELETE /images/sha256:feedface",
+			},
+		},
+		{
+			name:  "a running dump is kept",
+			state: "running",
+			want:  []string{"GET /containers/json", "GET /volumes", "GET /images/json"},
+		},
+		{
+			name:  "force",
+			state: "running",
+			force: true,
+			want: []string{
+				"GET /containers/json", "DELETE /containers/c0ffee",
+				"GET /volumes", "DELETE /volumes/gget-run",
+				"GET /images/json", "DELETE /images/sha256:feedface",
+			},
+		},
+		{
+			name:         "volume used by another container",
+			state:        "exited",
+			volumeStatus: http.StatusConflict,
+			want: []string{
+				"GET /containers/json", "DELETE /containers/c0ffee",
+				"GET /volumes", "DELETE /volumes/gget-run",
+				"GET /images/json", "DELETE /images/sha256:feedface",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var imageFilter string
+			// whether a volume was removed, and with force
+			var volumeRemoved, volumeForce bool
+			cli, calls := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodDelete && strings.Contains(r.URL.Path, "/images/"):
+					fmt.Fprint(w, `[{"Deleted":"sha256:feedface"}]`)
+				case r.Method == http.MethodDelete && strings.Contains(r.URL.Path, "/volumes/"):
+					volumeRemoved, volumeForce = true, r.URL.Query().Get("force") != ""
+					if tt.volumeStatus != 0 {
+						w.WriteHeader(tt.volumeStatus)
+						fmt.Fprint(w, `{"message":"volume is in use"}`)
+						return
+					}
+					w.WriteHeader(http.StatusNoContent)
+				case r.Method == http.MethodDelete:
+					w.WriteHeader(http.StatusNoContent)
+				case strings.HasSuffix(r.URL.Path, "/containers/json"):
+					fmt.Fprintf(w, `[{"Id":"c0ffee","ImageID":"sha256:feedface","State":%q,"Labels":{%q:"run"},"Mounts":[{"Type":"volume","Name":"gget-run"}]}]`, tt.state, labelRunID)
+				case strings.HasSuffix(r.URL.Path, "/volumes"):
+					fmt.Fprintf(w, `{"Volumes":[{"Name":"gget-run","Labels":{%q:"run"}}]}`, labelRunID)
+				case strings.HasSuffix(r.URL.Path, "/images/json"):
+					imageFilter = r.URL.Query().Get("filters")
+					fmt.Fprintf(w, `[{"Id":"sha256:feedface","RepoTags":["gget:latest","gget:old"],"Labels":{%q:"true"}}]`, labelImage)
+				default:
+					http.NotFound(w, r)
+				}
+			})
+			if err := Prune(context.Background(), cli.DaemonHost(), tt.dryRun, tt.force); err != nil {
+				t.Fatal(err)
+			}
+			if got := calls(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got calls %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(imageFilter, labelImage) {
+				t.Errorf("images were listed with filters %q, want the %s label", imageFilter, labelImage)
+			}
+			if volumeRemoved && volumeForce != tt.force {
+				t.Errorf("the volume was removed with force %t, want %t", volumeForce, tt.force)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// times a probe request answered 429 is tried again
+	rateLimitRetries = 3
+	// wait when a 429 carries no usable Retry-After
+	rateLimitDefault = 5 * time.Second
+	// a longer Retry-After isn't waited out, the probe fails instead
+	rateLimitMax = 2 * time.Minute
+)
+
+// context key carrying the run id a probe request is made for
+type runIDKey struct{}
+
+// backs off from hosts that answer 429 Too Many Requests. Every request to a
+// host waits until its Retry-After has passed, not just the one that got the
+// 429, so probing many targets on one host or CDN slows down as a whole.
+// timeout bounds each attempt rather than the whole request, so time spent
+// waiting for a host doesn't count against it.
+type rateLimitTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+
+	mu        sync.Mutex
+	notBefore map[string]time.Time
+}
+
+func newRateLimitTransport(next http.RoundTripper, timeout time.Duration) *rateLimitTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &rateLimitTransport{next: next, timeout: timeout, notBefore: map[string]time.Time{}}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	runID, _ := ctx.Value(runIDKey{}).(string)
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(ctx, req.URL.Host); err != nil {
+			return nil, err
+		}
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if t.timeout > 0 {
+			actx, cancel = context.WithTimeout(ctx, t.timeout)
+		}
+		resp, err := t.next.RoundTrip(req.Clone(actx))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		delay := retryAfter(resp.Header.Get("Retry-After"))
+		if attempt == rateLimitRetries || delay > rateLimitMax {
+			logWarning(runID, "PROBE", fmt.Sprintf("%s is rate limiting requests (Retry-After %s), giving up on %s", req.URL.Host, delay, req.URL.Redacted()))
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		cancel()
+		logWarning(runID, "PROBE", fmt.Sprintf("%s is rate limiting requests, backing off for %s, consider dumping fewer targets on it at once", req.URL.Host, delay))
+		t.backOff(req.URL.Host, delay)
+	}
+}
+
+// blocks until host may be sent another request
+func (t *rateLimitTransport) wait(ctx context.Context, host string) error {
+	t.mu.Lock()
+	until := t.notBefore[host]
+	t.mu.Unlock()
+	d := time.Until(until)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (t *rateLimitTransport) backOff(host string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.notBefore[host]) {
+		t.notBefore[host] = until
+	}
+}
+
+// reads a Retry-After given in seconds or as an http date
+func retryAfter(v string) time.Duration {
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if when, err := http.ParseTime(v); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d.Round(time.Second)
+		}
+		return 0
+	}
+	return rateLimitDefault
+}
+
+// releases an attempt's timeout once its body has been read
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
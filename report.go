@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// outcome of dumping a single target
+type Result struct {
+	RunID     string
+	Job       string
+	URL       string
+	OutputDir string
+	Status    string
+	ExitCode  int
+	Files     int
+	Bytes     int64
+	Objects   int
+	Duration  time.Duration
+	Err       error
+	// only part of the repository was fetched on purpose, as with -only-index
+	Partial bool
+	// the server listed .git, so the dump didn't depend on guessing objects
+	Listing bool
+	// status and Server header of the probe's last response for .git/HEAD,
+	// 0 when the probe didn't get one
+	ProbeStatus int
+	Server      string
+	// credentials -dump-config-only found in .git/config
+	Credentials int
+	// dumps rerun by -retry-on-empty
+	EmptyRetries int
+	// "<sha> <refname>" per branch and tag -list-refs found
+	Refs []string
+
+	ImageID       string
+	ImageReused   bool
+	BuildDuration time.Duration
+
+	// where -upload put the dump, or why it couldn't. Doesn't affect Status.
+	Upload    string
+	UploadErr error
+}
+
+// records how the dump ended and totals what ended up in the output directory
+func (r *Result) Finish(started time.Time, exitCode int, err error) {
+	r.Duration = time.Since(started)
+	r.ExitCode = exitCode
+	r.Err = err
+	switch {
+	case err == nil && r.Partial:
+		r.Status = "partial"
+	case err == nil:
+		r.Status = "ok"
+	case errors.Is(err, context.Canceled):
+		r.Status = "aborted"
+	case errors.Is(err, ErrOutOfScope), errors.Is(err, ErrTargetGated):
+		r.Status = "skipped"
+	default:
+		r.Status = "failed"
+	}
+	// the directory is gone after -clean-on-failure, which just means nothing was kept
+	if tree, err := readTree(r.OutputDir); err == nil {
+		r.Files = tree.Files
+		r.Bytes = tree.Size
+	}
+}
+
+var reportCSVHeader = []string{"run_id", "url", "output_dir", "status", "exit_code", "file_count", "bytes", "object_count", "listing", "duration", "error", "image_id", "image_reused", "build_duration", "upload", "upload_error", "job", "probe_status", "server", "config_credentials", "empty_retries", "ref_count", "refs"}
+
+// prints one line per result as an aligned table
+func WriteSummary(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tURL\tSTATUS\tFILES\tSIZE\tDURATION\tRETRIES\tERROR")
+	for _, r := range results {
+		var msg string
+		if r.Err != nil {
+			msg = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%d\t%s\n", r.RunID, redactURL(r.URL), r.Status, r.Files, humanSize(r.Bytes), r.Duration.Round(time.Second), r.EmptyRetries, msg)
+	}
+	return tw.Flush()
+}
+
+// writes one row per result to path, replacing whatever was there
+func WriteReportCSV(path string, results []Result) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	w.Write(reportCSVHeader)
+	for _, r := range results {
+		var msg, uploadMsg string
+		if r.Err != nil {
+			msg = r.Err.Error()
+		}
+		if r.UploadErr != nil {
+			uploadMsg = r.UploadErr.Error()
+		}
+		w.Write([]string{
+			r.RunID,
+			redactURL(r.URL),
+			r.OutputDir,
+			r.Status,
+			strconv.Itoa(r.ExitCode),
+			strconv.Itoa(r.Files),
+			strconv.FormatInt(r.Bytes, 10),
+			strconv.Itoa(r.Objects),
+			strconv.FormatBool(r.Listing),
+			r.Duration.Round(time.Millisecond).String(),
+			msg,
+			r.ImageID,
+			strconv.FormatBool(r.ImageReused),
+			r.BuildDuration.Round(time.Millisecond).String(),
+			r.Upload,
+			uploadMsg,
+			r.Job,
+			strconv.Itoa(r.ProbeStatus),
+			r.Server,
+			strconv.Itoa(r.Credentials),
+			strconv.Itoa(r.EmptyRetries),
+			strconv.Itoa(len(r.Refs)),
+			strings.Join(r.Refs, ";"),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the host the docker client talks to over an ssh connection, never resolved
+const sshDaemonHost = "http://docker.example.com"
+
+// returns a dialer that reaches the daemon behind an ssh://[user@]host[:port]
+// url by running `docker system dial-stdio` there, like the docker cli does.
+// Authentication is left to ssh, so keys from the agent and ~/.ssh/config apply.
+func sshDialer(daemonURL string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	u, err := url.Parse(daemonURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, fmt.Errorf("expected ssh://[user@]host[:port], got %q", daemonURL)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("ssh daemon url %q must not have a path", daemonURL)
+	}
+	var args []string
+	if u.User != nil {
+		args = append(args, "-l", u.User.Username())
+	}
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, "--", u.Hostname(), "docker", "system", "dial-stdio")
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialCommand(ctx, "ssh", args...)
+	}, nil
+}
+
+// a net.Conn over the stdin and stdout of a command
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+	once   sync.Once
+}
+
+func dialCommand(ctx context.Context, name string, args ...string) (net.Conn, error) {
+	// the connection outlives the dial, so ctx can't be the command's context
+	c := &commandConn{cmd: exec.Command(name, args...)}
+	c.cmd.Stderr = &c.stderr
+	var err error
+	if c.stdin, err = c.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if c.stdout, err = c.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting %s: %w", name, err)
+	}
+	return c, nil
+}
+
+func (c *commandConn) Read(b []byte) (int, error) {
+	n, err := c.stdout.Read(b)
+	if err == io.EOF && c.stderr.Len() > 0 {
+		return n, fmt.Errorf("%s: %s", c.cmd.Path, strings.TrimSpace(c.stderr.String()))
+	}
+	return n, err
+}
+
+func (c *commandConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+func (c *commandConn) Close() error {
+	c.once.Do(func() {
+		c.stdin.Close()
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	})
+	return nil
+}
+
+// the http client treats the command's pipes as a connection that has no
+// addresses or deadlines of its own
+func (c *commandConn) LocalAddr() net.Addr                { return commandAddr{} }
+func (c *commandConn) RemoteAddr() net.Addr               { return commandAddr{} }
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type commandAddr struct{}
+
+func (commandAddr) Network() string { return "command" }
+func (commandAddr) String() string  { return "command" }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// the first {a,b} or {1..9} group of a pattern
+	bracePattern = regexp.MustCompile(`\{([^{}]*)\}`)
+	// an ipv4 network in place of the host, e.g. https://10.0.0.0/24/.git
+	cidrPattern = regexp.MustCompile(`^([a-z]+://)(\d+\.\d+\.\d+\.\d+/\d+)(/.*)?$`)
+)
+
+// expands a -sweep pattern into concrete urls. Brace groups are expanded
+// first, shell style, then an ipv4 network standing in for the host becomes
+// one url per usable address. Fails rather than returning more than limit.
+func expandSweep(pattern string, limit int) ([]string, error) {
+	var urls []string
+	var expand func(s string) error
+	expand = func(s string) error {
+		loc := bracePattern.FindStringSubmatchIndex(s)
+		if loc == nil {
+			return expandCIDR(s, limit, &urls)
+		}
+		prefix, body, suffix := s[:loc[0]], s[loc[2]:loc[3]], s[loc[1]:]
+		alternatives, err := braceAlternatives(body, limit)
+		if err != nil {
+			return err
+		}
+		for _, alt := range alternatives {
+			if err := expand(prefix + alt + suffix); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := expand(pattern); err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+// the alternatives of one brace group, a comma separated list or a numeric
+// range like 1..20
+func braceAlternatives(body string, limit int) ([]string, error) {
+	if from, to, ok := strings.Cut(body, ".."); ok {
+		lo, err1 := strconv.Atoi(from)
+		hi, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil || lo > hi {
+			return nil, fmt.Errorf("brace range {%s} must be {low..high}", body)
+		}
+		if hi-lo+1 > limit {
+			return nil, fmt.Errorf("brace range {%s} expands to more than %d targets", body, limit)
+		}
+		var alts []string
+		for i := lo; i <= hi; i++ {
+			alts = append(alts, strconv.Itoa(i))
+		}
+		return alts, nil
+	}
+	if !strings.Contains(body, ",") {
+		return nil, fmt.Errorf("brace group {%s} must list alternatives with , or a range with ..", body)
+	}
+	return strings.Split(body, ","), nil
+}
+
+// appends s to urls, or one url per usable address when its host is a network
+func expandCIDR(s string, limit int, urls *[]string) error {
+	m := cidrPattern.FindStringSubmatch(s)
+	if m == nil {
+		if len(*urls) >= limit {
+			return fmt.Errorf("sweep expands to more than %d targets", limit)
+		}
+		*urls = append(*urls, s)
+		return nil
+	}
+	ip, network, err := net.ParseCIDR(m[2])
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("%s is not an ipv4 network", m[2])
+	}
+	ones, bits := network.Mask.Size()
+	size := uint64(1) << uint(bits-ones)
+	first, last := uint64(0), size-1
+	// the network and broadcast addresses aren't hosts, except in /31 and /32
+	if size > 2 {
+		first, last = 1, size-2
+	}
+	if last-first+1 > uint64(limit-len(*urls)) {
+		return fmt.Errorf("%s expands to %d targets, more than the %d allowed", m[2], last-first+1, limit)
+	}
+	base := binary.BigEndian.Uint32(network.IP.To4())
+	for i := first; i <= last; i++ {
+		addr := make(net.IP, 4)
+		binary.BigEndian.PutUint32(addr, base+uint32(i))
+		*urls = append(*urls, m[1]+addr.String()+m[3])
+	}
+	return nil
+}
+
+// probes every url's .git/HEAD, workers at a time, and returns in their
+// original order the ones that answer with something that looks like a ref
+func exposedTargets(ctx context.Context, hc *http.Client, urls []string, workers int) []string {
+	alive := make([]bool, len(urls))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				data, err := fetch(ctx, hc, gitBaseURL(urls[i])+"HEAD")
+				alive[i] = err == nil && looksLikeHead(data)
+			}
+		}()
+	}
+	for i := range urls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var exposed []string
+	for i, ok := range alive {
+		if ok {
+			exposed = append(exposed, urls[i])
+		}
+	}
+	return exposed
+}
+
+// HEAD is either a symbolic ref or a detached commit id
+func looksLikeHead(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("ref: refs/")) {
+		return true
+	}
+	if len(data) != 40 && len(data) != 64 {
+		return false
+	}
+	for _, c := range data {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
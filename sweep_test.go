@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandSweep(t *testing.T) {
+	tests := []struct {
+		pattern string
+		limit   int
+		want    []string
+		fails   bool
+	}{
+		{pattern: "http://example.com/.git", limit: 10, want: []string{"http://example.com/.git"}},
+		{pattern: "http://{a,b}.example.com/.git", limit: 10, want: []string{"http://a.example.com/.git", "http://b.example.com/.git"}},
+		{pattern: "http://app{1..3}.example.com/", limit: 10, want: []string{"http://app1.example.com/", "http://app2.example.com/", "http://app3.example.com/"}},
+		{pattern: "http://{a,b}.example.com/{x,y}/.git", limit: 10, want: []string{"http://a.example.com/x/.git", "http://a.example.com/y/.git", "http://b.example.com/x/.git", "http://b.example.com/y/.git"}},
+		{pattern: "http://10.0.0.0/30/.git", limit: 10, want: []string{"http://10.0.0.1/.git", "http://10.0.0.2/.git"}},
+		{pattern: "http://10.0.0.8/31", limit: 10, want: []string{"http://10.0.0.8", "http://10.0.0.9"}},
+		{pattern: "http://10.0.0.7/32/.git", limit: 10, want: []string{"http://10.0.0.7/.git"}},
+		{pattern: "http://10.0.{1,2}.0/31", limit: 10, want: []string{"http://10.0.1.0", "http://10.0.1.1", "http://10.0.2.0", "http://10.0.2.1"}},
+		{pattern: "http://10.0.0.0/24", limit: 100, fails: true},
+		{pattern: "http://h{1..20}/", limit: 10, fails: true},
+		{pattern: "http://{a,b,c}/{1,2,3,4}/", limit: 10, fails: true},
+		{pattern: "http://h{3..1}/", limit: 10, fails: true},
+		{pattern: "http://h{a}/", limit: 10, fails: true},
+		{pattern: "http://10.0.0.300/30", limit: 10, fails: true},
+	}
+	for _, tt := range tests {
+		got, err := expandSweep(tt.pattern, tt.limit)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("expandSweep(%q) = %q, want an error", tt.pattern, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandSweep(%q) = %q, %v, want %q", tt.pattern, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	neturl "net/url"
+	"path/filepath"
+	"strings"
+)
+
+// one url to dump and where its results go
+type target struct {
+	URL    string
+	Output string
+	Mirror string
+	// set by -jobs: the entry's name, and overrides for -branch and the
+	// options passed to git-dumper
+	Name       string
+	Branch     string
+	DumperArgs []string
+	// whether Output had to be created and whether it was empty beforehand,
+	// for -clean-on-failure
+	Created bool
+	Empty   bool
+}
+
+// expands -paths into a target per path under base. Each is dumped into a
+// subdirectory of output named after the whole path, so /a/app and /b/app
+// don't end up in the same place.
+func pathTargets(base string, output string, mirror string, paths []string) ([]target, error) {
+	seen := map[string]string{}
+	var targets []target
+	for _, p := range paths {
+		p = strings.Trim(strings.TrimSpace(p), "/")
+		for _, segment := range strings.Split(p, "/") {
+			if segment == "." || segment == ".." {
+				return nil, fmt.Errorf("-paths entry %q must not contain . or ..", p)
+			}
+		}
+		name := strings.ReplaceAll(p, "/", "_")
+		if name == "" {
+			name = "root"
+		}
+		if prev, ok := seen[name]; ok {
+			return nil, fmt.Errorf("-paths entries %q and %q would both dump into %s", "/"+prev, "/"+p, name)
+		}
+		seen[name] = p
+		t := target{
+			URL:    strings.TrimRight(base, "/") + "/" + p,
+			Output: filepath.Join(output, name),
+		}
+		if mirror != "" {
+			t.Mirror = filepath.Join(mirror, name)
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// a directory name for a target's output made of its host and path
+func targetDirName(u *neturl.URL) string {
+	// the .git every url ends in says nothing about which repository it is
+	name := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	return strings.NewReplacer("/", "_", ":", "_").Replace(strings.Trim(u.Host+"/"+name, "/"))
+}
+
+// a target per url under output, each in a directory named by targetDirName
+func urlTargets(urls []string, output string, mirror string) ([]target, error) {
+	seen := map[string]string{}
+	var targets []target
+	for _, raw := range urls {
+		u, err := neturl.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%q is not an http or https url", raw)
+		}
+		name := targetDirName(u)
+		if prev, ok := seen[name]; ok {
+			return nil, fmt.Errorf("%s and %s would both dump into %s", prev, raw, name)
+		}
+		seen[name] = raw
+		t := target{URL: raw, Output: filepath.Join(output, name)}
+		if mirror != "" {
+			t.Mirror = filepath.Join(mirror, name)
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
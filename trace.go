@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// logs every request the docker client sends before handing it on. Streamed
+// responses (build output, logs) are timed until their headers arrive.
+type traceTransport struct {
+	next  http.RoundTripper
+	runID string
+	mu    sync.Mutex
+	out   io.Writer
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(started).Round(time.Microsecond)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ts := started.Format("15:04:05.000")
+	if err != nil {
+		fmt.Fprintf(t.out, "%s [%s] %s %s error %v (%s)\n", ts, t.runID, req.Method, req.URL.RequestURI(), err, elapsed)
+	} else {
+		fmt.Fprintf(t.out, "%s [%s] %s %s %d (%s)\n", ts, t.runID, req.Method, req.URL.RequestURI(), resp.StatusCode, elapsed)
+	}
+	return resp, err
+}
+
+// wraps the transport client.FromEnv configured, so it has to come after it
+func withTrace(runID string, out io.Writer) client.Opt {
+	return func(c *client.Client) error {
+		hc := c.HTTPClient()
+		hc.Transport = &traceTransport{next: hc.Transport, runID: runID, out: out}
+		return client.WithHTTPClient(hc)(c)
+	}
+}
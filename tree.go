@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ttacon/chalk"
+)
+
+type treeNode struct {
+	Name     string
+	Dir      bool
+	Files    int
+	Size     int64
+	Children []*treeNode
+}
+
+// reads path into a tree, totalling file counts and sizes for every directory.
+// Symlinks are counted as files and never followed.
+func readTree(path string) (*treeNode, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	node := &treeNode{Name: info.Name(), Dir: info.IsDir()}
+	if !node.Dir {
+		node.Files = 1
+		node.Size = info.Size()
+		return node, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		child, err := readTree(filepath.Join(path, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		node.Files += child.Files
+		node.Size += child.Size
+		node.Children = append(node.Children, child)
+	}
+	// directories first, then by name, like tree --dirsfirst
+	sort.Slice(node.Children, func(i, j int) bool {
+		a, b := node.Children[i], node.Children[j]
+		if a.Dir != b.Dir {
+			return a.Dir
+		}
+		return a.Name < b.Name
+	})
+	return node, nil
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func (n *treeNode) label() string {
+	if n.Dir {
+		return fmt.Sprintf("%s %s", chalk.Blue.Color(n.Name+"/"), chalk.Dim.TextStyle(fmt.Sprintf("(%d files, %s)", n.Files, humanSize(n.Size))))
+	}
+	return fmt.Sprintf("%s %s", n.Name, chalk.Dim.TextStyle("("+humanSize(n.Size)+")"))
+}
+
+// prints the directory at root as a tree, expanding at most depth levels.
+// Collapsed directories still show their totals.
+func PrintTree(w io.Writer, root string, depth int) error {
+	node, err := readTree(root)
+	if err != nil {
+		return err
+	}
+	node.Name = root
+	fmt.Fprintln(w, node.label())
+	printTreeChildren(w, node, "", depth)
+	return nil
+}
+
+func printTreeChildren(w io.Writer, node *treeNode, prefix string, depth int) {
+	if depth <= 0 {
+		return
+	}
+	for i, child := range node.Children {
+		branch, indent := "├── ", "│   "
+		if i == len(node.Children)-1 {
+			branch, indent = "└── ", "    "
+		}
+		fmt.Fprintln(w, prefix+branch+child.label())
+		printTreeChildren(w, child, prefix+indent, depth-1)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// where -upload sends each dump, parsed from sftp:// or ssh://[user@]host[:port]/path
+type uploadTarget struct {
+	// "sftp" transfers through the sftp subsystem, "ssh" pipes into a shell
+	Scheme string
+	User   string
+	Host   string
+	Port   string
+	Path   string
+	// private key to authenticate with instead of the agent and ssh's defaults
+	KeyFile string
+}
+
+func parseUploadTarget(raw string) (*uploadTarget, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "sftp" && u.Scheme != "ssh") || u.Hostname() == "" {
+		return nil, fmt.Errorf("expected sftp:// or ssh://[user@]host[:port]/path, got %q", raw)
+	}
+	// ssh and sftp would take the host for an option
+	if strings.HasPrefix(u.Hostname(), "-") || strings.HasPrefix(u.User.Username(), "-") {
+		return nil, fmt.Errorf("upload url %q has a host or user starting with -", raw)
+	}
+	if u.Path == "" || u.Path == "/" {
+		return nil, fmt.Errorf("upload url %q needs a directory to upload into", raw)
+	}
+	t := &uploadTarget{Scheme: u.Scheme, Host: u.Hostname(), Port: u.Port(), Path: path.Clean(u.Path)}
+	if u.User != nil {
+		t.User = u.User.Username()
+	}
+	return t, nil
+}
+
+// sends output as a .tar.gz to the upload target, keyed by the dumped host so
+// scans of different hosts don't mix, and returns where it landed.
+// Authentication is ssh's own: the agent, ~/.ssh/config, or KeyFile. The
+// archive is written under a temporary name and renamed once complete, so a
+// failed upload never looks finished.
+func (t *uploadTarget) Upload(ctx context.Context, output string, targetURL string, runID string) (string, error) {
+	host := "unknown"
+	if u, err := url.Parse(targetURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	dir := path.Join(t.Path, host)
+	dest := path.Join(dir, filepath.Base(output)+"-"+runID+".tar.gz")
+
+	var err error
+	if t.Scheme == "sftp" {
+		err = t.sftp(ctx, output, dir, dest)
+	} else {
+		err = t.ssh(ctx, output, dir, dest)
+	}
+	if err != nil {
+		return "", fmt.Errorf("uploading to %s:%s: %w", t.Host, dest, err)
+	}
+	return t.Host + ":" + dest, nil
+}
+
+// options common to ssh and sftp, which spell the port flag differently
+func (t *uploadTarget) sshOptions(portFlag string) []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if t.User != "" {
+		args = append(args, "-o", "User="+t.User)
+	}
+	if t.Port != "" {
+		args = append(args, portFlag, t.Port)
+	}
+	if t.KeyFile != "" {
+		args = append(args, "-i", t.KeyFile)
+	}
+	return args
+}
+
+// streams the archive into a shell on the server, which needs mkdir, cat and mv
+func (t *uploadTarget) ssh(ctx context.Context, output string, dir string, dest string) error {
+	script := fmt.Sprintf("mkdir -p %s && cat > %s.part && mv %s.part %s", shellQuote(dir), shellQuote(dest), shellQuote(dest), shellQuote(dest))
+	args := append(t.sshOptions("-p"), "--", t.Host, script)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTarGz(pw, output))
+	}()
+	defer pr.Close()
+	return runUploadCommand(exec.CommandContext(ctx, "ssh", args...), pr)
+}
+
+// sftp can only put files, so the archive is written to a temporary file
+// first and then sent with a batch of sftp commands. Creating the directories
+// one level at a time stands in for mkdir -p, the - in front lets the ones
+// that already exist fail.
+func (t *uploadTarget) sftp(ctx context.Context, output string, dir string, dest string) error {
+	tmp, err := os.CreateTemp("", "gget-upload-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeTarGz(tmp, output); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	var batch strings.Builder
+	for _, d := range parentDirs(dir) {
+		fmt.Fprintf(&batch, "-mkdir %s\n", sftpQuote(d))
+	}
+	fmt.Fprintf(&batch, "put %s %s\n", sftpQuote(tmp.Name()), sftpQuote(dest+".part"))
+	fmt.Fprintf(&batch, "rename %s %s\n", sftpQuote(dest+".part"), sftpQuote(dest))
+
+	args := append(t.sshOptions("-P"), "-b", "-", t.Host)
+	return runUploadCommand(exec.CommandContext(ctx, "sftp", args...), strings.NewReader(batch.String()))
+}
+
+// runs cmd with stdin, adding what it printed on stderr to a failure
+func runUploadCommand(cmd *exec.Cmd, stdin io.Reader) error {
+	var stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func writeTarGz(w io.Writer, dir string) error {
+	gz := gzip.NewWriter(w)
+	if err := writeTar(gz, dir, nil); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// every directory from the root down to dir, /a/b gives /a and /a/b
+func parentDirs(dir string) []string {
+	var dirs []string
+	for d := path.Clean(dir); d != "/" && d != "."; d = path.Dir(d) {
+		dirs = append([]string{d}, dirs...)
+	}
+	return dirs
+}
+
+// quotes an argument of an sftp batch command
+func sftpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseUploadTarget(t *testing.T) {
+	tests := []struct {
+		raw   string
+		want  *uploadTarget
+		fails bool
+	}{
+		{raw: "sftp://recon@storage.internal/srv/dumps/", want: &uploadTarget{Scheme: "sftp", User: "recon", Host: "storage.internal", Path: "/srv/dumps"}},
+		{raw: "ssh://storage.internal:2222/srv", want: &uploadTarget{Scheme: "ssh", Host: "storage.internal", Port: "2222", Path: "/srv"}},
+		{raw: "scp://storage.internal/srv", fails: true},
+		{raw: "sftp://storage.internal", fails: true},
+		{raw: "sftp://storage.internal/", fails: true},
+		{raw: "sftp://-oProxyCommand=x/srv", fails: true},
+		{raw: "sftp://-x@host/srv", fails: true},
+	}
+	for _, tt := range tests {
+		got, err := parseUploadTarget(tt.raw)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("parseUploadTarget(%q) = %+v, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseUploadTarget(%q) = %+v, %v, want %+v", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+// the sftp upload, run against a stand-in sftp that records its arguments
+// and the batch it was given
+func TestUploadSFTP(t *testing.T) {
+	bin := t.TempDir()
+	record := filepath.Join(t.TempDir(), "record")
+	script := "#!/bin/sh\necho \"$@\" > " + record + "\ncat >> " + record + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "sftp"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	output := filepath.Join(t.TempDir(), "dump")
+	if err := os.MkdirAll(filepath.Join(output, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	target := &uploadTarget{Scheme: "sftp", User: "recon", Host: "storage", Port: "2222", Path: `/srv/my "dumps"`}
+	dest, err := target.Upload(context.Background(), output, "http://example.com/.git", "abcd1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `storage:/srv/my "dumps"/example.com/dump-abcd1234.tar.gz`; dest != want {
+		t.Errorf("uploaded to %s, want %s", dest, want)
+	}
+	data, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if want := "-o BatchMode=yes -o User=recon -P 2222 -b - storage"; lines[0] != want {
+		t.Errorf("sftp ran with %q, want %q", lines[0], want)
+	}
+	want := []string{
+		`-mkdir "/srv"`,
+		`-mkdir "/srv/my \"dumps\""`,
+		`-mkdir "/srv/my \"dumps\"/example.com"`,
+		`rename "/srv/my \"dumps\"/example.com/dump-abcd1234.tar.gz.part" "/srv/my \"dumps\"/example.com/dump-abcd1234.tar.gz"`,
+	}
+	batch := append(append([]string{}, lines[1:4]...), lines[5:]...)
+	if !reflect.DeepEqual(batch, want) {
+		t.Errorf("batch is %q, want %q", batch, want)
+	}
+	if !strings.HasPrefix(lines[4], "put ") || !strings.HasSuffix(lines[4], `"/srv/my \"dumps\"/example.com/dump-abcd1234.tar.gz.part"`) {
+		t.Errorf("put line is %q", lines[4])
+	}
+}